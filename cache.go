@@ -0,0 +1,137 @@
+package recordio
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// ChunkCache is an LRU cache of decoded chunks bounded by the total
+// size of records.  It is safe for concurrent use, so Scanners,
+// FileListScanners, and Readers could share one through the
+// WithChunkCache option to avoid decoding the same chunk repeatedly.
+// Records returned from cached chunks are shared by all readers and
+// must not be modified.
+type ChunkCache struct {
+	maxBytes     int64
+	hits, misses int64 // accessed atomically.
+
+	mu    sync.Mutex
+	bytes int64
+	lru   *list.List // of *cacheEntry, the most recently used first.
+	items map[cacheKey]*list.Element
+}
+
+// cacheKey identifies a chunk.  file is a fileKey if the chunk is
+// read from a named file like *os.File, or the *Index otherwise.
+type cacheKey struct {
+	file  interface{}
+	chunk int
+}
+
+// fileKey identifies a file by its absolute path, size, and
+// modification time, so all readers of the file share its chunks, but
+// not after the file is rewritten.
+type fileKey struct {
+	path          string
+	size, modTime int64
+}
+
+func newFileKey(fn string, fi os.FileInfo) fileKey {
+	if abs, e := filepath.Abs(fn); e == nil {
+		fn = abs
+	}
+	return fileKey{path: fn, size: fi.Size(), modTime: fi.ModTime().UnixNano()}
+}
+
+// cacheFile returns how a ChunkCache identifies the file read by r,
+// whose index is idx.
+func cacheFile(r interface{}, idx *Index) interface{} {
+	if f, ok := r.(interface {
+		Name() string
+		Stat() (os.FileInfo, error)
+	}); ok {
+		if fi, e := f.Stat(); e == nil {
+			return newFileKey(f.Name(), fi)
+		}
+	}
+	return idx
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	chunk *chunk
+}
+
+// NewChunkCache creates a cache that holds at most maxBytes bytes of
+// records.
+func NewChunkCache(maxBytes int64) *ChunkCache {
+	return &ChunkCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[cacheKey]*list.Element),
+	}
+}
+
+// WithChunkCache makes scanners and readers look up chunks in c
+// before reading them from files, and add chunks they read into c.
+// Chunks of files opened by name, like *os.File, are shared by all
+// readers of the file until it is modified; chunks of other
+// io.Readers are shared by readers of the same *Index.
+func WithChunkCache(c *ChunkCache) ScanOption {
+	return func(o *ScanOptions) { o.Cache = c }
+}
+
+// Hits returns the number of lookups that found the chunk.
+func (c *ChunkCache) Hits() int64 {
+	return atomic.LoadInt64(&c.hits)
+}
+
+// Misses returns the number of lookups that did not find the chunk.
+func (c *ChunkCache) Misses() int64 {
+	return atomic.LoadInt64(&c.misses)
+}
+
+// Bytes returns the total size of records in the cache.
+func (c *ChunkCache) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+func (c *ChunkCache) get(k cacheKey) *chunk {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		c.lru.MoveToFront(el)
+		atomic.AddInt64(&c.hits, 1)
+		return el.Value.(*cacheEntry).chunk
+	}
+	atomic.AddInt64(&c.misses, 1)
+	return nil
+}
+
+func (c *ChunkCache) put(k cacheKey, ch *chunk) {
+	size := int64(ch.numBytes)
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok { // Added by another reader.
+		c.lru.MoveToFront(el)
+		return
+	}
+	for c.bytes+size > c.maxBytes {
+		el := c.lru.Back()
+		e := el.Value.(*cacheEntry)
+		c.lru.Remove(el)
+		delete(c.items, e.key)
+		c.bytes -= int64(e.chunk.numBytes)
+	}
+	c.items[k] = c.lru.PushFront(&cacheEntry{key: k, chunk: ch})
+	c.bytes += size
+}
@@ -2,16 +2,21 @@ package recordio
 
 import (
 	"bytes"
-	"compress/gzip"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
 	"io"
-	"log"
-
-	"github.com/golang/snappy"
+	"math"
 )
 
+// maxNarrowChunkSize is the maximum size of the uncompressed data of
+// a chunk, i.e., records and their 32-bit length prefixes, written
+// with 32-bit sizes.  Larger chunks have flagWide set.  It is a
+// variable for testing.
+var maxNarrowChunkSize = math.MaxUint32 / 2
+
+const maxInt = int(^uint(0) >> 1)
+
 // A chunk contains the Header and optionally compressed records.  To
 // create a chunk, just use ch := &chunk{}.
 type chunk struct {
@@ -25,44 +30,65 @@ func (ch *chunk) add(record []byte) {
 }
 
 // write a chunk, including the header and compressed chunk data.
-func (ch *chunk) write(w io.Writer, compressorID int) error {
+// It returns the number of bytes written.
+func (ch *chunk) write(w io.Writer, compressorID, level int) (int64, error) {
 	// NOTE: don't check ch.numBytes as we allow empty records.
 	if len(ch.records) == 0 {
-		return nil
+		return 0, nil
+	}
+
+	// Compressors could expand incompressible data, so if the
+	// compressed size of a narrow chunk doesn't fit in uint32 after
+	// all, compress it again as a wide chunk.
+	var flags uint8
+	if uint64(ch.numBytes)+4*uint64(len(ch.records)) > uint64(maxNarrowChunkSize) {
+		flags |= flagWide
 	}
 
 	var buf bytes.Buffer
-	chksum, e := ch.compress(compressorID, &buf)
+	chksum, e := ch.compress(compressorID, level, flags&flagWide != 0, &buf)
+	if e == nil && flags&flagWide == 0 && uint64(buf.Len()) > math.MaxUint32 {
+		flags |= flagWide
+		buf.Reset()
+		chksum, e = ch.compress(compressorID, level, true, &buf)
+	}
 	if e != nil {
-		return e
+		return 0, e
 	}
 
 	// Write chunk header and compressed data.
 	hdr := &header{
 		checkSum:       chksum,
 		compressor:     uint32(compressorID),
-		compressedSize: uint32(buf.Len()),
+		compressedSize: uint64(buf.Len()),
 		numRecords:     uint32(len(ch.records)),
+		flags:          flags,
 	}
-	if _, e := hdr.write(w); e != nil {
-		return fmt.Errorf("Failed to write chunk header: %v", e)
+	hn, e := hdr.write(w)
+	if e != nil {
+		return 0, fmt.Errorf("Failed to write chunk header: %v", e)
 	}
-	if _, e := w.Write(buf.Bytes()); e != nil {
-		return fmt.Errorf("Failed to write chunk data: %v", e)
+	dn, e := w.Write(buf.Bytes())
+	if e != nil {
+		return 0, fmt.Errorf("Failed to write chunk data: %v", e)
 	}
 
 	// Clear the current chunk.
 	ch.records = nil
 	ch.numBytes = 0
 
-	return nil
+	return int64(hn + dn), nil
 }
 
-// compress chunk data (records) into a buffer and returns the CRC32 checksum.
-func (ch *chunk) compress(compressorID int, buf *bytes.Buffer) (uint32, error) {
-	// In addition to notations introduced in the function
-	// definition of read, we add the following:
+// compress chunk data (records) into a buffer and returns the CRC32
+// checksum.  If wide, record lengths are written as uint64 instead of
+// uint32.
+func (ch *chunk) compress(compressorID, level int, wide bool, buf *bytes.Buffer) (uint32, error) {
+	// To help designing a complex I/O pipeline using Go's io
+	// package, let us introduce the following notations:
 	//
+	// > : a writer
+	// >(crc32) : a CRC32 hash is a writer.
 	// >(buf) : a bytes.Buffer is a writer.
 	// >(compr)> : a compressor wraps a writer into another writer.
 	//
@@ -72,14 +98,23 @@ func (ch *chunk) compress(compressorID int, buf *bytes.Buffer) (uint32, error) {
 	// write->(compr)>(crc32, buf)
 	chksum := crc32.NewIEEE()
 	mw := io.MultiWriter(buf, chksum)
-	compr := newCompressor(mw, compressorID)
+	compr, e := newCompressor(mw, compressorID, level)
+	if e != nil {
+		return 0, e
+	}
 
 	// Write raw records and their lengths into data buffer.
 	for _, r := range ch.records {
-		var rs [4]byte
-		binary.LittleEndian.PutUint32(rs[:], uint32(len(r)))
+		var rs [8]byte
+		n := 4
+		if wide {
+			binary.LittleEndian.PutUint64(rs[:], uint64(len(r)))
+			n = 8
+		} else {
+			binary.LittleEndian.PutUint32(rs[:], uint32(len(r)))
+		}
 
-		if _, e := compr.Write(rs[:]); e != nil {
+		if _, e := compr.Write(rs[:n]); e != nil {
 			return 0, fmt.Errorf("Failed to write record length: %v", e)
 		}
 
@@ -94,106 +129,90 @@ func (ch *chunk) compress(compressorID int, buf *bytes.Buffer) (uint32, error) {
 	return chksum.Sum32(), nil
 }
 
-// TODO: use ioutil.WriteNopCloser once the following PR is in public release:
-// https://go-review.googlesource.com/c/go/+/175779#message-31dfdd1aaee623f9e80fb652af7bd0cc8cc4fcc3
-type writeNopCloser struct {
-	io.Writer
+// readChunkAt reads the chunk at offset of r using positional reads,
+// so it is safe to call concurrently on the same r.
+func readChunkAt(r io.ReaderAt, offset int64) (*chunk, error) {
+	return readChunk(io.NewSectionReader(r, offset, math.MaxInt64-offset))
 }
 
-func (writeNopCloser) Close() error { return nil }
-
-func newCompressor(w io.Writer, compressorID int) io.WriteCloser {
-	switch compressorID {
-	case NoCompression:
-		return writeNopCloser{w}
-	case Snappy:
-		return snappy.NewWriter(w)
-	case Gzip:
-		return gzip.NewWriter(w)
-	default:
-		log.Fatalf("Unknown compressor ID: %d", compressorID)
+// readRawChunkAt reads the header and the compressed data of the
+// chunk at offset of r without decoding them.
+func readRawChunkAt(r io.ReaderAt, offset int64) ([]byte, error) {
+	sr := io.NewSectionReader(r, offset, math.MaxInt64-offset)
+	var buf bytes.Buffer
+	hdr, e := parseHeader(io.TeeReader(sr, &buf))
+	if e != nil {
+		return nil, e
 	}
-	return nil
+	// Don't trust the header to allocate the buffer at once.
+	if _, e := io.CopyN(&buf, sr, int64(hdr.compressedSize)); e != nil {
+		return nil, unexpectedEOF(e)
+	}
+	return buf.Bytes(), nil
 }
 
 // readChunk from r into the memory.
 func readChunk(r io.Reader) (*chunk, error) {
 	hdr, e := parseHeader(r)
-	if e != nil {
-		return nil, e // NOTE: must return e literally as required by FileListScanner.
-	}
-
-	// To help designing a complex I/O pipeline using Go's io
-	// package, let us introduce the following notations:
-	//
-	// ▶ : a reader
-	// > : a writer
-	// (file)▶ : an opened file is a reader
-	// >(crc32) : a CRC32 hash is a writer
-	// >(buf): a bytes.Buffer is a writer
-	// (file)▶-copy->(buf) : io.Copy copies content from a file to a buffer
-	// ▶(decomp)▶ : a decompressor wraps a reader into another reader
-	// >(pipe)▶ : io.Pipe returns a pair of reader and writer
-	// ▶(tee)>
-	//   ▶      : io.TeeReader takes a reader and a writer and returns a branch
-	//
-	// The decompressing and checksum pipeline looks as follows:
-	//
-	// (file)▶-copy->(pipe)▶(tee)>(crc32)
-	//                        ▶(decomp)▶-read
-	//
-	pr, pw := io.Pipe()
-	chksum := crc32.NewIEEE()
-	br := io.TeeReader(pr, chksum)
-	decomp, e := newDecompressor(br, int(hdr.compressor))
 	if e != nil {
 		return nil, e
 	}
 
-	// Intake data.
-	var e1 error
-	go func() {
-		defer pw.Close()
-		_, e1 = io.CopyN(pw, r, int64(hdr.compressedSize))
-	}()
-
-	// Outtake data.
-	ch := &chunk{}
-	for i := 0; i < int(hdr.numRecords); i++ {
-		var rs [4]byte
-		if _, e = io.ReadFull(decomp, rs[:]); e != nil {
-			return nil, fmt.Errorf("Failed to read record length: %v", e)
-		}
-
-		l := int(binary.LittleEndian.Uint32(rs[:]))
-		r := make([]byte, l)
-		_, e := io.ReadFull(decomp, r)
-		if e != nil {
-			return nil, fmt.Errorf("Failed to read a record: %v", e)
-		}
-		ch.records = append(ch.records, r)
-		ch.numBytes += len(r)
+	// Don't trust the header to allocate the buffer at once.
+	var buf bytes.Buffer
+	if _, e := io.CopyN(&buf, r, int64(hdr.compressedSize)); e != nil {
+		return nil, fmt.Errorf("Failed to read chunk data: %v", unexpectedEOF(e))
 	}
+	return decodePayload(hdr, buf.Bytes(), nil)
+}
 
-	if e1 != nil {
-		return nil, e1
+// decodePayload checks and decompresses the chunk data following hdr
+// and splits it into records.  If buf is nil, each record is a copy,
+// so keeping one doesn't keep the whole chunk in the memory.
+// Otherwise, records of an uncompressed chunk point into payload, and
+// those of a compressed chunk into buf, which is overwritten.
+func decodePayload(hdr *header, payload []byte, buf *bytes.Buffer) (*chunk, error) {
+	if sum := crc32.ChecksumIEEE(payload); hdr.checkSum != sum {
+		return nil, fmt.Errorf("Checksum checking failed. %d vs %d", hdr.checkSum, sum)
+	}
+	alias := buf != nil
+	if hdr.compressor == NoCompression {
+		return splitRecords(payload, hdr, alias)
 	}
 
-	if hdr.checkSum != chksum.Sum32() {
-		return nil, fmt.Errorf("Checksum checking failed. %d vs %d", hdr.checkSum, chksum.Sum32())
+	decomp, e := newDecompressor(bytes.NewReader(payload), int(hdr.compressor))
+	if e != nil {
+		return nil, e
 	}
+	defer decomp.Close()
 
-	return ch, nil
+	if buf == nil {
+		buf = new(bytes.Buffer)
+	}
+	buf.Reset()
+	if _, e := buf.ReadFrom(decomp); e != nil {
+		return nil, fmt.Errorf("Failed to decompress chunk: %v", e)
+	}
+	return splitRecords(buf.Bytes(), hdr, alias)
 }
 
-func newDecompressor(src io.Reader, compressorID int) (io.Reader, error) {
-	switch compressorID {
-	case NoCompression:
-		return src, nil
-	case Snappy:
-		return snappy.NewReader(src), nil
-	case Gzip:
-		return gzip.NewReader(src)
+// readRecordLen reads the length prefix of a record, which is uint64
+// in wide chunks and uint32 otherwise.
+func readRecordLen(r io.Reader, wide bool) (int, error) {
+	var rs [8]byte
+	if !wide {
+		if _, e := io.ReadFull(r, rs[:4]); e != nil {
+			return 0, fmt.Errorf("Failed to read record length: %v", e)
+		}
+		return int(binary.LittleEndian.Uint32(rs[:4])), nil
+	}
+
+	if _, e := io.ReadFull(r, rs[:]); e != nil {
+		return 0, fmt.Errorf("Failed to read record length: %v", e)
+	}
+	l := binary.LittleEndian.Uint64(rs[:])
+	if l > uint64(maxInt) {
+		return 0, fmt.Errorf("Record length %d exceeds the address space", l)
 	}
-	return nil, fmt.Errorf("Unknown compression algorithm: %d", compressorID)
+	return int(l), nil
 }
@@ -0,0 +1,194 @@
+package recordio
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// DefaultLevel asks a compressor to use its own default compression
+// level.
+const DefaultLevel = -1
+
+// CompressorFunc wraps w into a writer that compresses data at the
+// given level.  Compressors without levels may ignore level.
+type CompressorFunc func(w io.Writer, level int) (io.WriteCloser, error)
+
+// DecompressorFunc wraps r into a reader that decompresses data
+// written by the matching CompressorFunc.
+type DecompressorFunc func(r io.Reader) (io.ReadCloser, error)
+
+// UnknownCompressorError is returned when a chunk refers to a
+// compressor ID that has not been registered.
+type UnknownCompressorError uint32
+
+func (e UnknownCompressorError) Error() string {
+	return fmt.Sprintf("Unknown compressor ID: %d", uint32(e))
+}
+
+type compressor struct {
+	name         string
+	compressor   CompressorFunc
+	decompressor DecompressorFunc
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = make(map[uint32]compressor)
+)
+
+func init() {
+	RegisterCompressor(NoCompression, "none",
+		func(w io.Writer, level int) (io.WriteCloser, error) {
+			return writeNopCloser{w}, nil
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(r), nil
+		})
+	RegisterCompressor(Snappy, "snappy",
+		func(w io.Writer, level int) (io.WriteCloser, error) {
+			return snappy.NewWriter(w), nil
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(snappy.NewReader(r)), nil
+		})
+	RegisterCompressor(Gzip, "gzip",
+		func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		})
+	RegisterCompressor(Zstd, "zstd", newZstdWriter, newZstdReader)
+	RegisterCompressor(LZ4, "lz4", newLZ4Writer,
+		func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(lz4.NewReader(r)), nil
+		})
+}
+
+func newZstdWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	l := zstd.SpeedDefault
+	if level != DefaultLevel {
+		if level < 1 || level > 22 {
+			return nil, fmt.Errorf("Invalid zstd level %d", level)
+		}
+		l = zstd.EncoderLevelFromZstd(level)
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(l))
+}
+
+// zstdReader adapts zstd.Decoder, whose Close returns no error, to
+// io.ReadCloser.  Close releases the decoder's resources.
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (r zstdReader) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, e := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if e != nil {
+		return nil, e
+	}
+	return zstdReader{d}, nil
+}
+
+var lz4Levels = []lz4.CompressionLevel{
+	lz4.Fast,
+	lz4.Level1, lz4.Level2, lz4.Level3,
+	lz4.Level4, lz4.Level5, lz4.Level6,
+	lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+func newLZ4Writer(w io.Writer, level int) (io.WriteCloser, error) {
+	lw := lz4.NewWriter(w)
+	if level != DefaultLevel {
+		if level < 0 || level >= len(lz4Levels) {
+			return nil, fmt.Errorf("Invalid lz4 level %d", level)
+		}
+		if e := lw.Apply(lz4.CompressionLevelOption(lz4Levels[level])); e != nil {
+			return nil, e
+		}
+	}
+	return lw, nil
+}
+
+// RegisterCompressor makes a compression algorithm available to
+// Writer and readers under the given ID, which is recorded in every
+// chunk header.  It is usually called from an init function.  It
+// panics if the ID is already registered or if either function is
+// nil.
+func RegisterCompressor(id uint32, name string, newWriter CompressorFunc, newReader DecompressorFunc) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	if newWriter == nil || newReader == nil {
+		panic("recordio: RegisterCompressor with nil function for " + name)
+	}
+	if c, dup := compressors[id]; dup {
+		panic(fmt.Sprintf("recordio: RegisterCompressor called twice for ID %d (%s and %s)", id, c.name, name))
+	}
+	compressors[id] = compressor{
+		name:         name,
+		compressor:   newWriter,
+		decompressor: newReader,
+	}
+}
+
+// unregisterCompressor removes the compressor ID, for testing.
+func unregisterCompressor(id uint32) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	delete(compressors, id)
+}
+
+// CompressorName returns the name under which the compressor ID was
+// registered.  It returns false if the ID is unknown.
+func CompressorName(id uint32) (string, bool) {
+	c, ok := lookupCompressor(id)
+	return c.name, ok
+}
+
+func lookupCompressor(id uint32) (compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[id]
+	return c, ok
+}
+
+// TODO: use ioutil.WriteNopCloser once the following PR is in public release:
+// https://go-review.googlesource.com/c/go/+/175779#message-31dfdd1aaee623f9e80fb652af7bd0cc8cc4fcc3
+type writeNopCloser struct {
+	io.Writer
+}
+
+func (writeNopCloser) Close() error { return nil }
+
+func newCompressor(w io.Writer, compressorID, level int) (io.WriteCloser, error) {
+	c, ok := lookupCompressor(uint32(compressorID))
+	if !ok {
+		return nil, UnknownCompressorError(compressorID)
+	}
+	compr, e := c.compressor(w, level)
+	if e != nil {
+		return nil, fmt.Errorf("Failed to create %s compressor: %v", c.name, e)
+	}
+	return compr, nil
+}
+
+func newDecompressor(src io.Reader, compressorID int) (io.ReadCloser, error) {
+	c, ok := lookupCompressor(uint32(compressorID))
+	if !ok {
+		return nil, UnknownCompressorError(compressorID)
+	}
+	return c.decompressor(src)
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"log"
 	"os"
-	"path/filepath"
 
 	"github.com/wangkuiyi/recordio"
 )
@@ -40,10 +39,7 @@ func syncReadOldFile() {
 }
 
 func asyncReadOldFile() {
-	fs, e := filepath.Glob("mnist/train/data-*")
-	noErr(e)
-
-	fl, e := recordio.NewFileList(fs)
+	fl, e := recordio.NewFileListFromGlob("mnist/train/data-*")
 	noErr(e)
 
 	s := recordio.NewFileListScanner(fl, -1, -1)
@@ -1,71 +1,146 @@
 package recordio
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
+	"sync"
 
 	"github.com/wangkuiyi/parallel"
 )
 
 type FileList struct {
-	files         []string // filename list
-	indices       []*Index // index per file
-	accumFileLens []int    // accumulative file sizes in records
+	files         []string    // filename list
+	indices       []*Index    // index per file, nil until loaded by index
+	loads         []sync.Once // load indices lazily
+	loadErrs      []error
+	sizes         []int64 // file sizes in bytes, for Fingerprint
+	accumFileLens []int   // accumulative file sizes in records
 }
 
-// NewFileList builds indices of a set of files.
+// NewFileList builds indices of a set of files.  It reads the index
+// file written by WriteIndexFile if it is up to date with the data
+// file, or loads the index from the data file otherwise.
+//
+// With the SkipCorrupt option, it builds indices of files without
+// footers even if some chunk headers are corrupted, like LoadIndex.
+// It calls OnCorrupt from one goroutine at a time.
 //
 // NOTE: If a caller is going to create more than one FileList objects
 // that scan the same set of files, the caller must make sure that
 // they have the same file list of the same order in parameter fn.
-func NewFileList(fn []string) (*FileList, error) {
+// NewFileListFromGlob, NewFileListFromDir, and
+// NewFileListFromManifest sort files to do so.
+func NewFileList(fn []string, opts ...ScanOption) (*FileList, error) {
 	idcs := make([]*Index, len(fn))
+	sizes := make([]int64, len(fn))
+	o := newScanOptions(opts)
+	var mu sync.Mutex
 
-	if e := parallel.For(0, len(fn), 1, func(i int) error {
-		f, e := os.Open(fn[i])
+	if e := parallel.For(0, len(fn), 1, func(i int) (e error) {
+		o := o
+		if f := o.OnCorrupt; f != nil {
+			o.OnCorrupt = func(c Corruption) {
+				c.File = fn[i]
+				mu.Lock()
+				defer mu.Unlock()
+				f(c)
+			}
+		}
+		fi, e := os.Stat(fn[i])
 		if e != nil {
 			return e
 		}
-		defer f.Close()
-
-		idcs[i], e = LoadIndex(f)
+		sizes[i] = fi.Size()
+		idcs[i], e = loadIndex(fn[i], &o)
 		return e
 	}); e != nil {
 		return nil, e
 	}
 
+	counts := make([]int, len(fn))
+	for i, idx := range idcs {
+		counts[i] = idx.NumRecords()
+	}
+	return newFileList(fn, idcs, sizes, counts), nil
+}
+
+// newFileList creates a FileList of files fn, with counts records.
+// Nil indices are loaded by index when needed.
+func newFileList(fn []string, idcs []*Index, sizes []int64, counts []int) *FileList {
 	accum := 0
 	accumFileLens := make([]int, len(fn))
-	for i, idx := range idcs {
-		accum += idx.NumRecords()
+	for i, n := range counts {
+		accum += n
 		accumFileLens[i] = accum
 	}
 
 	return &FileList{
 		files:         fn,
 		indices:       idcs,
-		accumFileLens: accumFileLens}, nil
+		loads:         make([]sync.Once, len(fn)),
+		loadErrs:      make([]error, len(fn)),
+		sizes:         sizes,
+		accumFileLens: accumFileLens}
+}
+
+// index returns the index of the i-th file, and loads it at the first
+// call if it is not loaded yet.  It fails if the file doesn't have as
+// many records as fs expects.
+func (fs *FileList) index(i int) (*Index, error) {
+	fs.loads[i].Do(func() {
+		if fs.indices[i] != nil {
+			return
+		}
+		o := newScanOptions(nil)
+		idx, e := loadIndex(fs.files[i], &o)
+		if e == nil && idx.NumRecords() != fs.numRecords(i) {
+			e = fmt.Errorf("%s has %d records, but %d are expected", fs.files[i], idx.NumRecords(), fs.numRecords(i))
+		}
+		if e != nil {
+			fs.loadErrs[i] = e
+			return
+		}
+		fs.indices[i] = idx
+	})
+	return fs.indices[i], fs.loadErrs[i]
 }
 
+// numRecords returns the number of records in the i-th file.
+func (fs *FileList) numRecords(i int) int {
+	return fs.accumFileLens[i] - fs.first(i)
+}
+
+// Locate returns the file, the chunk, and the record in the chunk of
+// the recordIndex-th record, or -1s if there is no such record, or
+// the index of the file fails to load.
 func (fs *FileList) Locate(recordIndex int) (file, chunk, record int) {
+	file, chunk, record, e := fs.locate(recordIndex)
+	if e != nil {
+		return -1, -1, -1
+	}
+	return file, chunk, record
+}
+
+// locate is like Locate, but returns the error loading the index.
+func (fs *FileList) locate(recordIndex int) (file, chunk, record int, err error) {
 	file = sort.Search(len(fs.accumFileLens), func(i int) bool {
 		return recordIndex < fs.accumFileLens[i]
 	})
 	if file >= len(fs.files) {
-		return -1, -1, -1
+		return -1, -1, -1, nil
 	}
 
-	prevAccum := 0
-	if chunk > 0 {
-		prevAccum = fs.accumFileLens[file-1]
+	idx, e := fs.index(file)
+	if e != nil {
+		return -1, -1, -1, e
 	}
-
-	chunk, record = fs.indices[file].Locate(recordIndex - prevAccum)
-	return file, chunk, record
-
+	chunk, record = idx.Locate(recordIndex - fs.first(file))
+	return file, chunk, record, nil
 }
 
 func (fs *FileList) TotalRecords() int {
@@ -79,15 +154,63 @@ var (
 	ErrStopped = errors.New("FileListScanner.Close() stopped scanning")
 )
 
+// Record is a record emitted by FileListScanner.
+type Record struct {
+	Epoch  int // counting from 0.
+	Index  int // the index of the record in the FileList.
+	Offset int // the number of records before it in the epoch.
+	Data   []byte
+}
+
 type FileListScanner struct {
 	fl         *FileList
 	start, end int         // A logical view of the range.
-	ch         chan []byte // From background reading goroutine to Next().
+	ch         chan Record // From background reading goroutine to Records().
+	data       chan []byte // Or to Chan(), records without Epoch and Index.
 	stop       chan int    // From Close() to the background goroutine.
-	err        error
+	stopOnce   sync.Once
+	done       chan int // Closed when the background goroutine returns.
+	mu         sync.Mutex
+	err        error // Guarded by mu.
+	opts       ScanOptions
+
+	// For Position, guarded by mu: the last records sent, in a ring,
+	// the numbers of records sent and consumed, and the progress made
+	// by the latter.
+	sent      []sentRecord
+	nsent     int
+	nconsumed int
+	progress  progress
+
+	chooseOnce sync.Once
+	chosen     chan int // Closed when Chan or Records is called.
+	raw        bool     // Chan was called, so records go to data.
+}
+
+// NewFileListScanner creates a scanner of records in the range
+// [start, start+len) of fl.  If start < 0, it scans from the first
+// record.  If len < 0, it scans till the last record.
+func NewFileListScanner(fl *FileList, start, len int, opts ...ScanOption) *FileListScanner {
+	return NewFileListScannerWithOptions(fl, start, len, ScanOptions{}, opts...)
+}
+
+// NewFileListScannerContext is like NewFileListScanner, but the
+// scanner stops when ctx is done, and Error returns ctx.Err().
+func NewFileListScannerContext(ctx context.Context, fl *FileList, start, len int, opts ...ScanOption) *FileListScanner {
+	return NewFileListScanner(fl, start, len, append(opts, WithContext(ctx))...)
+}
+
+// NewFileListScannerWithOptions is like NewFileListScanner, but
+// configured by o and opts, which override o.
+func NewFileListScannerWithOptions(fl *FileList, start, len int, o ScanOptions, opts ...ScanOption) *FileListScanner {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.setDefaults()
+	return newFileListScanner(fl, start, len, o)
 }
 
-func NewFileListScanner(fl *FileList, start, len int) *FileListScanner {
+func newFileListScanner(fl *FileList, start, len int, o ScanOptions) *FileListScanner {
 	if start < 0 {
 		start = 0
 	}
@@ -96,88 +219,472 @@ func NewFileListScanner(fl *FileList, start, len int) *FileListScanner {
 	}
 
 	rs := &FileListScanner{
-		fl:    fl,
-		start: start,
-		end:   start + len,
-		ch:    make(chan []byte, 1000), // Buffer size is critial to performance. Currently ad-hoc.
-		stop:  make(chan int)}
+		fl:     fl,
+		start:  start,
+		end:    start + len,
+		ch:     make(chan Record, o.BufferSize), // Buffer size is critial to performance.
+		data:   make(chan []byte, o.BufferSize),
+		stop:   make(chan int),
+		done:   make(chan int),
+		opts:   o,
+		chosen: make(chan int),
+		sent:   make([]sentRecord, o.BufferSize+1),
+	}
+	rs.progress.epoch, rs.progress.offset = o.Epoch, o.Offset
 
-	go func() { rs.err = rs.scan() }()
+	go func() {
+		defer close(rs.done)
+		e := rs.scan()
+		rs.mu.Lock()
+		rs.err = e
+		rs.mu.Unlock()
+		close(rs.ch) // After setting err, so Error is final once ch is drained.
+		close(rs.data)
+	}()
 	return rs
 }
 
-func (scnr *FileListScanner) scan() error {
-	defer close(scnr.ch) // No more emits to ch after read returns,
+// Close stops scanning, and waits for the background goroutines to
+// exit and close the file.  Records already in Chan could still be
+// received after Close.  It returns the error that stopped scanning
+// before Close, if any.  It is safe to call Close more than once, or
+// after scanning is complete.
+func (scnr *FileListScanner) Close() error {
+	scnr.stopOnce.Do(func() { close(scnr.stop) })
+	<-scnr.done
+	if e := scnr.Error(); e != ErrStopped {
+		return e
+	}
+	return nil
+}
 
-	cur := scnr.start
-	file, chunk, record := scnr.fl.Locate(cur)
-	for cur < scnr.end {
-		n, e := scnr.scanFile(file, chunk, record, scnr.end-cur)
-		if e != nil && e != io.EOF {
+// chunkTask is a chunk that a FileListScanner reads, decodes, and
+// emits.
+type chunkTask struct {
+	file, index  int // the index-th chunk of the file-th file.
+	record, todo int // emit todo records since the record-th.
+	first        int // the index of the record-th record in the FileList.
+	window       int // the shuffle window, if shuffling.
+
+	idx       *Index      // of the file.
+	cacheFile interface{} // identifies the file in the ChunkCache.
+
+	raw   []byte // undecoded chunk read from the file.
+	chunk *chunk
+	err   error
+	fatal bool          // err is not a corrupted chunk.
+	done  chan struct{} // closed when chunk or err is ready.
+}
+
+// pipeline connects the reading goroutine, the decoding workers, and
+// the emitting goroutine of a FileListScanner.
+type pipeline struct {
+	slots chan struct{}   // limits chunks read ahead of emitting.
+	work  chan *chunkTask // from the reader to workers.
+	out   chan *chunkTask // to the emitter, in order if ordered.
+	quit  chan struct{}   // closed when the emitter returns.
+}
+
+// scan scans the range in each epoch.
+func (scnr *FileListScanner) scan() error {
+	o := &scnr.opts
+	if o.Epoch < 0 || o.Offset < 0 {
+		return fmt.Errorf("Cannot resume at epoch %d, offset %d", o.Epoch, o.Offset)
+	}
+	epochs := o.Epochs
+	if epochs == 0 || scnr.end <= scnr.start { // Don't spin on an empty range.
+		epochs = o.Epoch + 1
+	}
+	offset := o.Offset
+	for epoch := o.Epoch; epochs < 0 || epoch < epochs; epoch++ {
+		if e := scnr.scanEpoch(epoch, offset); e != nil {
 			return e
 		}
-		cur += n
-		file++
-		chunk, record = 0, 0 // Since the second file, read from the first record.
+		offset = 0
 	}
 	return nil
 }
 
-// scanFile reads at most todo records from the record-th in chunk of
-// file.  It returns when it reaches the end of the file or having
-// read enough number of records.  In either case, it returns the
-// number of read records.
-func (scnr *FileListScanner) scanFile(file, chunk, record, todo int) (done int, err error) {
-	f, e := os.Open(scnr.fl.files[file])
+// scanEpoch reads and decodes chunks in the range using a pipeline of
+// goroutines, and emits their records.  If the Ordered option is
+// false, chunks are emitted as soon as they are decoded.
+func (scnr *FileListScanner) scanEpoch(epoch, offset int) error {
+	o := &scnr.opts
+	tasks, skip, e := scnr.plan(epoch, offset)
 	if e != nil {
-		return 0, e
+		return e
+	}
+	if o.Shuffle {
+		o.Unordered = false // Shuffling is deterministic only in order.
+	}
+	// A task takes a slot before entering work or out, so sending to
+	// them never blocks, as their capacities equal that of slots.
+	p := &pipeline{
+		slots: make(chan struct{}, o.ReadAhead),
+		work:  make(chan *chunkTask, o.ReadAhead),
+		out:   make(chan *chunkTask, o.ReadAhead),
+		quit:  make(chan struct{}),
 	}
-	defer f.Close()
+	var wg sync.WaitGroup
+	defer func() {
+		close(p.quit) // Stops the reader and workers.
+		wg.Wait()
+	}()
 
-	idx := scnr.fl.indices[file]
-	if _, e := f.Seek(idx.chunkOffsets[chunk], io.SeekStart); e != nil {
-		return 0, fmt.Errorf("Failed to seek to chunk: %v", e)
+	wg.Add(1 + o.Workers)
+	go func() {
+		defer wg.Done()
+		scnr.read(p, tasks)
+	}()
+	for i := 0; i < o.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			scnr.decode(p)
+		}()
 	}
+	go func() {
+		wg.Wait()
+		close(p.out)
+	}()
 
-	for todo > 0 {
-		n, e := scnr.scanChunk(f, record, todo)
-		todo -= n
-		done += n
+	w := &window{epoch: epoch, index: -1, skip: skip, offset: offset}
+	for t := range p.out {
+		if e := scnr.stopped(); e != nil {
+			return e
+		}
+		select {
+		case <-t.done:
+		case <-scnr.stop:
+			return ErrStopped
+		case <-o.Context.Done():
+			return o.Context.Err()
+		}
+		var e error
+		if o.Shuffle {
+			e = scnr.shuffle(w, t)
+		} else {
+			e = scnr.emit(t, epoch)
+		}
 		if e != nil {
-			return done, e
+			return e
+		}
+		<-p.slots
+	}
+	if o.Shuffle {
+		return scnr.flush(w)
+	}
+	return nil
+}
+
+// plan returns chunks to read in order, and the number of records to
+// skip in the first shuffle window.  It loads indices of files to
+// read.
+func (scnr *FileListScanner) plan(epoch, offset int) ([]*chunkTask, int, error) {
+	var tasks []*chunkTask
+
+	cur := scnr.start
+	if !scnr.opts.Shuffle {
+		cur += offset
+	}
+	if cur >= scnr.end {
+		return nil, 0, nil
+	}
+	file, chunk, record, e := scnr.fl.locate(cur)
+	if e != nil {
+		return nil, 0, e
+	}
+	for cur < scnr.end {
+		idx, e := scnr.fl.index(file)
+		if e != nil {
+			return nil, 0, e
+		}
+		if chunk >= idx.NumChunks() {
+			file++
+			chunk, record = 0, 0 // Since the second file, read from the first record.
+			continue
+		}
+
+		t := &chunkTask{file: file, idx: idx, index: chunk, record: record, first: cur, done: make(chan struct{})}
+		t.todo = idx.chunkRecords[chunk] - record
+		if t.todo > scnr.end-cur {
+			t.todo = scnr.end - cur
 		}
+		tasks = append(tasks, t)
+		cur += t.todo
+		chunk++
 		record = 0 // Since the second chunk, we read since its first record.
 	}
 
-	return done, nil
+	if !scnr.opts.Shuffle {
+		return tasks, 0, nil
+	}
+	tasks, skip := shuffleTasks(tasks, scnr.opts.Seed, epoch, offset, scnr.opts.ShuffleWindow)
+	return tasks, skip, nil
 }
 
-// scanChunk reads r for a chunk and returns at most todo records
-// starting from the record-th.
-func (scnr *FileListScanner) scanChunk(r io.Reader, record, todo int) (done int, err error) {
-	chnk, e := readChunk(r)
+// read reads chunks from files, and sends them to workers.  Cached
+// chunks and those failing to read skip workers.
+func (scnr *FileListScanner) read(p *pipeline, tasks []*chunkTask) {
+	defer close(p.work)
+
+	files := &openFiles{max: scnr.opts.MaxOpenFiles}
+	defer files.close()
+	var id interface{}
+	identified := -1 // the index of the file identified by id.
+
+	for _, t := range tasks {
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.quit:
+			return
+		}
+		if !scnr.opts.Unordered {
+			p.out <- t
+		}
+
+		fn, idx := scnr.fl.files[t.file], t.idx
+		if scnr.opts.Cache != nil {
+			if t.file != identified {
+				id, identified = idx, t.file
+				if fi, e := os.Stat(fn); e == nil {
+					id = newFileKey(fn, fi)
+				}
+			}
+			t.cacheFile = id
+			if t.chunk = scnr.opts.cached(id, t.index); t.chunk != nil {
+				scnr.finish(p, t)
+				continue
+			}
+		}
+		f, e := files.open(t.file, fn)
+		if e != nil {
+			t.err, t.fatal = e, true
+			scnr.finish(p, t)
+			return
+		}
+		if t.raw, t.err = readRawChunkAt(f, idx.chunkOffsets[t.index]); t.err != nil {
+			scnr.finish(p, t)
+			continue
+		}
+		p.work <- t
+	}
+}
+
+// openFiles keeps at most max files open for a FileListScanner.
+type openFiles struct {
+	max   int
+	files []openFile // the most recently used first.
+}
+
+type openFile struct {
+	index int // in the file list.
+	f     *os.File
+}
+
+// open returns the file of the given index in the file list, opening
+// it and closing the least recently used one if necessary.
+func (o *openFiles) open(index int, fn string) (*os.File, error) {
+	for i, of := range o.files {
+		if of.index == index {
+			copy(o.files[1:i+1], o.files[:i])
+			o.files[0] = of
+			return of.f, nil
+		}
+	}
+
+	f, e := os.Open(fn)
 	if e != nil {
-		return done, e
+		return nil, e
+	}
+	if len(o.files) >= o.max {
+		o.files[len(o.files)-1].f.Close()
+	} else {
+		o.files = append(o.files, openFile{})
+	}
+	copy(o.files[1:], o.files)
+	o.files[0] = openFile{index: index, f: f}
+	return f, nil
+}
+
+// close closes all open files.
+func (o *openFiles) close() {
+	for _, of := range o.files {
+		of.f.Close()
+	}
+	o.files = nil
+}
+
+// decode decompresses chunks sent by read.
+func (scnr *FileListScanner) decode(p *pipeline) {
+	for t := range p.work {
+		t.chunk, t.err = readChunk(bytes.NewReader(t.raw))
+		t.raw = nil
+		if t.err == nil {
+			scnr.opts.addCache(t.cacheFile, t.index, t.chunk)
+		}
+		scnr.finish(p, t)
+	}
+}
+
+// finish marks t ready to emit.
+func (scnr *FileListScanner) finish(p *pipeline, t *chunkTask) {
+	close(t.done)
+	if scnr.opts.Unordered {
+		p.out <- t
+	}
+}
+
+// emit emits records of t, or reports it if it is corrupted.
+func (scnr *FileListScanner) emit(t *chunkTask, epoch int) error {
+	if t.err != nil {
+		if e := scnr.corrupt(t); e != nil {
+			return e
+		}
+		scnr.mu.Lock()
+		defer scnr.mu.Unlock()
+		for i := 0; i < t.todo; i++ { // No need to consume them.
+			scnr.progress.mark(sentRecord{epoch, t.first + i - scnr.start})
+		}
+		return nil
+	}
+	_, e := scnr.scanChunk(t, epoch)
+	return e
+}
+
+// corrupt reports t failing to read, and returns the error if the
+// scanner should stop.
+func (scnr *FileListScanner) corrupt(t *chunkTask) error {
+	if t.fatal || !scnr.opts.SkipCorrupt {
+		return t.err
 	}
 
-	for i := record; todo > 0 && i < len(chnk.records); i++ {
+	fn, idx := scnr.fl.files[t.file], t.idx
+	var f io.ReadSeeker // to find the end of the last chunk.
+	if t.index+1 >= idx.NumChunks() {
+		if file, e := os.Open(fn); e == nil {
+			defer file.Close()
+			f = file
+		}
+	}
+	scnr.opts.corrupt(Corruption{
+		File:    fn,
+		Offset:  idx.chunkOffsets[t.index],
+		Size:    idx.chunkSize(t.index, f),
+		Records: t.todo,
+		Err:     t.err,
+	})
+	return nil
+}
+
+// scanChunk emits at most t.todo records of t.chunk starting from
+// the t.record-th.
+func (scnr *FileListScanner) scanChunk(t *chunkTask, epoch int) (done int, err error) {
+	for i := t.record; done < t.todo && i < len(t.chunk.records); i++ {
+		r := Record{
+			Epoch:  epoch,
+			Index:  t.first + done,
+			Offset: t.first + done - scnr.start,
+			Data:   t.chunk.records[i],
+		}
+		if e := scnr.send(r); e != nil {
+			return done, e
+		}
+		done++
+	}
+	return done, nil
+}
+
+// stopped returns the error that stops the scanner if it has been
+// closed or its context is done, or nil.  Checking it before a select
+// makes stopping win over a ready channel, which select would choose
+// at random.
+func (scnr *FileListScanner) stopped() error {
+	select {
+	case <-scnr.stop:
+		return ErrStopped
+	case <-scnr.opts.Context.Done():
+		return scnr.opts.Context.Err()
+	default:
+		return nil
+	}
+}
+
+// send emits a record to the channel returned by Chan or Records,
+// waiting for either to be called, unless the scanner is stopped.
+func (scnr *FileListScanner) send(r Record) error {
+	select {
+	case <-scnr.chosen:
+	case <-scnr.stop:
+		return ErrStopped
+	case <-scnr.opts.Context.Done():
+		return scnr.opts.Context.Err()
+	}
+	if e := scnr.stopped(); e != nil {
+		return e
+	}
+	ch, data := scnr.ch, scnr.data
+	if scnr.raw {
+		ch = nil // Never ready.
+	} else {
+		data = nil
+	}
+
+	// Sending r and counting it under mu keeps Position exact, unless
+	// the channel is full.
+	scnr.mu.Lock()
+	select {
+	case ch <- r:
+	case data <- r.Data:
+	default:
+		scnr.mu.Unlock()
 		select {
 		case <-scnr.stop:
-			return done, ErrStopped
+			return ErrStopped
+
+		case <-scnr.opts.Context.Done():
+			return scnr.opts.Context.Err()
 
-		default:
-			scnr.ch <- chnk.records[i]
-			done++
-			todo--
+		case ch <- r:
+		case data <- r.Data:
 		}
+		scnr.mu.Lock()
 	}
-	return done, nil
+	scnr.sent[scnr.nsent%len(scnr.sent)] = sentRecord{r.Epoch, r.Offset}
+	scnr.nsent++
+	scnr.consumed(scnr.nsent - scnr.opts.BufferSize) // The rest fit in the channel.
+	scnr.mu.Unlock()
+	return nil
 }
 
+// choose makes the scanner send records to data if raw, or to ch.
+// Only the first call takes effect.
+func (fl *FileListScanner) choose(raw bool) {
+	fl.chooseOnce.Do(func() {
+		fl.raw = raw
+		close(fl.chosen)
+	})
+}
+
+// Chan returns the channel of records, which is closed when scanning
+// stops.  Use either Chan or Records, but not both.
 func (fl *FileListScanner) Chan() chan ([]byte) {
+	fl.choose(true)
+	return fl.data
+}
+
+// Records returns the channel of records with their epochs and
+// indices, which is closed when scanning stops.  Use either Chan or
+// Records, but not both.
+func (fl *FileListScanner) Records() <-chan Record {
+	fl.choose(false)
 	return fl.ch
 }
 
+// Error returns the error that stopped scanning, or nil if scanning
+// completed.  The result is final only after the channel returned by
+// Chan is closed, or Close returns.
 func (fl *FileListScanner) Error() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
 	return fl.err
 }
@@ -1,10 +1,18 @@
 package recordio
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
+	"runtime"
+	"sort"
 	"testing"
 	"time"
 
@@ -70,6 +78,29 @@ func TestNewFileList(t *testing.T) {
 	a.NoError(scnr.Error())
 }
 
+func TestFileListLocate(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, _, e := synthesizeNumberedFiles(4, NoCompression)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files) // of 0, 10, 20, and 30 records.
+	a.NoError(e)
+	for _, c := range []struct{ record, file, chunk, inChunk int }{
+		{0, 1, 0, 0},
+		{9, 1, 2, 1},
+		{10, 2, 0, 0}, // The first record of the second non-empty file.
+		{25, 2, 3, 3},
+		{30, 3, 0, 0},
+		{59, 3, 7, 1},
+		{60, -1, -1, -1},
+	} {
+		file, chunk, record := fl.Locate(c.record)
+		a.Equal([]int{c.file, c.chunk, c.inChunk}, []int{file, chunk, record}, "record %d", c.record)
+	}
+}
+
 func BenchmarkSyncAndAsyncRead(b *testing.B) {
 	const records = 200
 
@@ -117,3 +148,1177 @@ func BenchmarkSyncAndAsyncRead(b *testing.B) {
 			}
 		})
 }
+
+func TestIndexFile(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, e := synthesizeFiles()
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fn := files[len(files)-1]
+	f, e := os.Open(fn)
+	a.NoError(e)
+	idx, e := LoadIndex(f)
+	a.NoError(e)
+	a.NoError(f.Close())
+
+	var buf bytes.Buffer
+	n, e := idx.WriteTo(&buf)
+	a.NoError(e)
+	a.Equal(int64(buf.Len()), n)
+	b := append([]byte(nil), buf.Bytes()...)
+	read, e := ReadIndex(&buf)
+	a.NoError(e)
+	a.Equal(idx, read)
+
+	// A corrupted number of chunks fails without allocating for them.
+	binary.LittleEndian.PutUint32(b[4:8], math.MaxUint32)
+	_, e = ReadIndex(bytes.NewReader(b))
+	a.Error(e)
+
+	_, e = ReadIndexFile(fn)
+	a.True(os.IsNotExist(e))
+
+	a.NoError(WriteIndexFile(fn, idx))
+	read, e = ReadIndexFile(fn)
+	a.NoError(e)
+	a.Equal(idx, read)
+
+	// NewFileList uses the index file.  Make it distinguishable.
+	fake := newIndex([]int64{0}, []int{1})
+	a.NoError(WriteIndexFile(fn, fake))
+	fl, e := NewFileList(files)
+	a.NoError(e)
+	a.Equal(fake, fl.indices[len(files)-1])
+
+	// Touching the data file invalidates the index file.
+	later := time.Now().Add(time.Hour)
+	a.NoError(os.Chtimes(fn, later, later))
+	_, e = ReadIndexFile(fn)
+	a.Equal(ErrStaleIndex, e)
+	fl, e = NewFileList(files)
+	a.NoError(e)
+	a.Equal(idx, fl.indices[len(files)-1])
+}
+
+func TestCorruptHeader(t *testing.T) {
+	a := assert.New(t)
+
+	f, e := ioutil.TempFile("", "recordio-corrupt-header")
+	a.NoError(e)
+	defer os.Remove(f.Name())
+
+	w := NewWriter(f, 10, Snappy)
+	for i := 0; i < 10; i++ {
+		_, e := w.Write([]byte(fmt.Sprintf("record-%d", i)))
+		a.NoError(e)
+	}
+	footer := w.offset
+	a.NoError(w.Close()) // closes f.
+
+	data, e := ioutil.ReadFile(f.Name())
+	a.NoError(e)
+	idx, e := LoadIndex(bytes.NewReader(data))
+	a.NoError(e)
+
+	// Flip a bit in numRecords of the second chunk.
+	data[idx.chunkOffsets[1]+20] ^= 0x10
+	a.NoError(ioutil.WriteFile(f.Name(), data, 0644))
+
+	_, e = LoadIndex(bytes.NewReader(data[:footer])) // scans headers.
+	a.Equal(ErrCorruptHeader, e)
+
+	s := NewScanner(bytes.NewReader(data), idx, -1, -1)
+	n := 0
+	for s.Scan() {
+		n++
+	}
+	a.Equal(1, n)
+	a.Equal(ErrCorruptHeader, s.Error())
+
+	fl, e := NewFileList([]string{f.Name()}) // uses the intact footer.
+	a.NoError(e)
+	scnr := NewFileListScanner(fl, -1, -1)
+	n = 0
+	for range scnr.Chan() {
+		n++
+	}
+	a.Equal(1, n)
+	a.Equal(ErrCorruptHeader, scnr.Error())
+}
+
+func TestSkipCorrupt(t *testing.T) {
+	a := assert.New(t)
+
+	f, e := ioutil.TempFile("", "recordio-skip-corrupt")
+	a.NoError(e)
+	defer os.Remove(f.Name())
+
+	w := NewWriter(f, 20, NoCompression)
+	for i := 0; i < 20; i++ { // 10 chunks of 2 records.
+		_, e := w.Write([]byte(fmt.Sprintf("record-%02d", i)))
+		a.NoError(e)
+	}
+	a.NoError(w.Close()) // closes f.
+
+	data, e := ioutil.ReadFile(f.Name())
+	a.NoError(e)
+	idx, e := LoadIndex(bytes.NewReader(data))
+	a.NoError(e)
+	a.Equal(10, idx.NumChunks())
+
+	data[idx.chunkOffsets[1]+34] ^= 0x01 // payload of chunk 1 fails CRC.
+	data[idx.chunkOffsets[3]+20] ^= 0x01 // header of chunk 3 is corrupted.
+	data[idx.chunkOffsets[9]+34] ^= 0x01 // so is the payload of the last chunk.
+	a.NoError(ioutil.WriteFile(f.Name(), data, 0644))
+
+	var want []string
+	for i := 1; i < 19; i++ {
+		if c := i / 2; c != 1 && c != 3 && c != 9 {
+			want = append(want, fmt.Sprintf("record-%02d", i))
+		}
+	}
+
+	var skipped []Corruption
+	s := NewScanner(bytes.NewReader(data), idx, 1, 18,
+		SkipCorrupt(func(c Corruption) { skipped = append(skipped, c) }))
+	var got []string
+	for s.Scan() {
+		got = append(got, string(s.Record()))
+	}
+	a.Equal(io.EOF, s.Error())
+	a.Equal(want, got)
+	a.Equal(3, len(skipped))
+	a.Equal(idx.chunkOffsets[1], skipped[0].Offset)
+	a.Equal(idx.chunkOffsets[2]-idx.chunkOffsets[1], skipped[0].Size)
+	a.Equal(2, skipped[0].Records)
+	a.Equal(ErrCorruptHeader, skipped[1].Err)
+	end, e := dataEnd(bytes.NewReader(data))
+	a.NoError(e)
+	a.Equal(end-idx.chunkOffsets[9], skipped[2].Size) // till the footer.
+	a.Equal(1, skipped[2].Records)                    // Only record 18 is in the range.
+
+	fl, e := NewFileList([]string{f.Name()})
+	a.NoError(e)
+	var flSkipped []Corruption
+	scnr := NewFileListScanner(fl, 1, 18,
+		SkipCorrupt(func(c Corruption) { flSkipped = append(flSkipped, c) }))
+	got = nil
+	for r := range scnr.Chan() {
+		got = append(got, string(r))
+	}
+	a.NoError(scnr.Error())
+	a.Equal(want, got)
+	for i := range skipped {
+		skipped[i].File = f.Name()
+	}
+	a.Equal(skipped, flSkipped)
+
+	// Without SkipCorrupt, scanners stop at the first bad chunk.
+	scnr = NewFileListScanner(fl, -1, -1)
+	n := 0
+	for range scnr.Chan() {
+		n++
+	}
+	a.Equal(2, n)
+	a.Error(scnr.Error())
+}
+
+func TestSkipCorruptWithoutFooter(t *testing.T) {
+	a := assert.New(t)
+
+	f, e := ioutil.TempFile("", "recordio-skip-corrupt-legacy")
+	a.NoError(e)
+	defer os.Remove(f.Name())
+
+	w := NewWriter(f, 20, NoCompression)
+	for i := 0; i < 20; i++ { // 10 chunks of 2 records.
+		_, e := w.Write([]byte(fmt.Sprintf("record-%02d", i)))
+		a.NoError(e)
+	}
+	a.NoError(w.Close()) // closes f.
+
+	data, e := ioutil.ReadFile(f.Name())
+	a.NoError(e)
+	idx, e := LoadIndex(bytes.NewReader(data))
+	a.NoError(e)
+	footer, e := dataEnd(bytes.NewReader(data))
+	a.NoError(e)
+	a.Equal(w.offset, footer)
+
+	// A legacy file without a footer, whose chunk 3 has a bad header.
+	data = data[:footer]
+	data[idx.chunkOffsets[3]+20] ^= 0x01
+	a.NoError(ioutil.WriteFile(f.Name(), data, 0644))
+	_, e = LoadIndex(bytes.NewReader(data))
+	a.Equal(ErrCorruptHeader, e)
+	_, e = NewFileList([]string{f.Name()})
+	a.Equal(ErrCorruptHeader, e)
+
+	want := Corruption{
+		Offset:  idx.chunkOffsets[3],
+		Size:    idx.chunkOffsets[4] - idx.chunkOffsets[3],
+		Records: -1,
+		Err:     ErrCorruptHeader,
+	}
+	var skipped []Corruption
+	legacy, e := LoadIndex(bytes.NewReader(data),
+		SkipCorrupt(func(c Corruption) { skipped = append(skipped, c) }))
+	a.NoError(e)
+	a.Equal([]Corruption{want}, skipped)
+	a.Equal(9, legacy.NumChunks())
+	a.Equal(18, legacy.NumRecords())
+
+	skipped = nil
+	fl, e := NewFileList([]string{f.Name()},
+		SkipCorrupt(func(c Corruption) { skipped = append(skipped, c) }))
+	a.NoError(e)
+	want.File = f.Name()
+	a.Equal([]Corruption{want}, skipped)
+
+	var got []string
+	for r := range NewFileListScanner(fl, -1, -1).Chan() {
+		got = append(got, string(r))
+	}
+	a.Equal(18, len(got))
+	a.Equal("record-05", got[5])
+	a.Equal("record-08", got[6])
+}
+
+func TestSkipCorruptFakeHeaders(t *testing.T) {
+	a := assert.New(t)
+
+	// Records that look like unchecked v1 and v2 headers of chunks
+	// with many records.
+	fakeV1 := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(fakeV1[0:4], magicNumber)
+	binary.LittleEndian.PutUint32(fakeV1[16:20], 0xfffffff0)
+	fakeV2 := make([]byte, headerV2Size)
+	binary.LittleEndian.PutUint32(fakeV2[0:4], magicNumberV2)
+	fakeV2[4] = headerVersion
+	binary.LittleEndian.PutUint16(fakeV2[6:8], headerV2Size)
+	binary.LittleEndian.PutUint32(fakeV2[12:16], Snappy)
+	binary.LittleEndian.PutUint32(fakeV2[20:24], 0xfffffff0)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 80, NoCompression)
+	for i := 0; i < 10; i++ { // 2 chunks.
+		r := []byte(fmt.Sprintf("record-%02d", i))
+		switch i {
+		case 1:
+			r = fakeV1
+		case 2:
+			r = fakeV2
+		}
+		_, e := w.Write(r)
+		a.NoError(e)
+	}
+	a.NoError(w.Close())
+	idx, e := LoadIndex(bytes.NewReader(buf.Bytes()))
+	a.NoError(e)
+	a.Equal(2, idx.NumChunks())
+	footer, e := dataEnd(bytes.NewReader(buf.Bytes()))
+	a.NoError(e)
+
+	for _, corrupt := range []int64{
+		5, // A bad header checksum.
+		0, // A bad magic number, so the file might have v1 headers.
+	} {
+		data := append([]byte(nil), buf.Bytes()[:footer]...)
+		data[corrupt] ^= 0x10
+		var skipped []Corruption
+		legacy, e := LoadIndex(bytes.NewReader(data),
+			SkipCorrupt(func(c Corruption) { skipped = append(skipped, c) }))
+		a.NoError(e)
+		a.Equal(1, len(skipped))
+		a.Equal(idx.chunkOffsets[1], skipped[0].Size)
+		a.Equal(idx.chunkOffsets[1:], legacy.chunkOffsets)
+		a.Equal(idx.chunkRecords[1:], legacy.chunkRecords)
+	}
+}
+
+func TestChunkCache(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, e := synthesizeFiles()
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+	chunks := 0
+	for _, idx := range fl.indices {
+		chunks += idx.NumChunks()
+	}
+
+	cache := NewChunkCache(1 << 20)
+	for i := 0; i < 2; i++ {
+		scnr := NewFileListScanner(fl, -1, -1, WithChunkCache(cache))
+		n := 0
+		for range scnr.Chan() {
+			n++
+		}
+		a.NoError(scnr.Error())
+		a.Equal(fl.TotalRecords(), n)
+	}
+	a.Equal(int64(chunks), cache.Misses())
+	a.Equal(int64(chunks), cache.Hits())
+
+	// Scanners over the same file share chunks through the cache.
+	f, e := os.Open(files[9])
+	a.NoError(e)
+	defer f.Close()
+	idx := fl.indices[9]
+	cache = NewChunkCache(1 << 20)
+	for i := 0; i < 2; i++ {
+		s := NewScanner(f, idx, -1, -1, WithChunkCache(cache))
+		for s.Scan() {
+		}
+		a.Equal(io.EOF, s.Error())
+	}
+	a.Equal(int64(idx.NumChunks()), cache.Misses())
+	a.Equal(int64(idx.NumChunks()), cache.Hits())
+
+	// The cache evicts the least recently used chunks.
+	cache = NewChunkCache(100)
+	r := NewReader(f, idx, WithChunkCache(cache))
+	for i := 0; i < idx.NumRecords(); i++ {
+		_, e := r.Get(i)
+		a.NoError(e)
+		a.True(cache.Bytes() <= 100)
+	}
+	a.True(cache.Bytes() > 0)
+	h := cache.Hits()
+	_, e = r.Get(idx.NumRecords() - 1)
+	a.NoError(e)
+	a.Equal(h+1, cache.Hits())
+	_, e = r.Get(0)
+	a.NoError(e)
+	a.Equal(h+1, cache.Hits())
+
+	// A Scanner fills the cache for FileListScanners of the same file.
+	cache = NewChunkCache(1 << 20)
+	s := NewScanner(f, idx, -1, -1, WithChunkCache(cache))
+	for s.Scan() {
+	}
+	a.Equal(io.EOF, s.Error())
+	one, e := NewFileList(files[9:])
+	a.NoError(e)
+	scan := func(fl *FileList) (got [][]byte) {
+		scnr := NewFileListScanner(fl, -1, -1, WithChunkCache(cache))
+		for r := range scnr.Chan() {
+			got = append(got, r)
+		}
+		a.NoError(scnr.Error())
+		return got
+	}
+	scan(one)
+	a.Equal(int64(idx.NumChunks()), cache.Misses())
+	a.Equal(int64(idx.NumChunks()), cache.Hits())
+
+	// Rewriting the file invalidates its chunks in the cache.
+	g, e := os.Create(files[9])
+	a.NoError(e)
+	w := NewWriter(g, 10*10, Snappy)
+	for j := 0; j < 9; j++ {
+		_, e := w.Write(bytes.Repeat([]byte{'x'}, j*10))
+		a.NoError(e)
+	}
+	a.NoError(w.Close())
+	later := time.Now().Add(time.Hour)
+	a.NoError(os.Chtimes(files[9], later, later))
+	one, e = NewFileList(files[9:])
+	a.NoError(e)
+	got := scan(one)
+	a.Equal(bytes.Repeat([]byte{'x'}, 80), got[8])
+	a.Equal(int64(idx.NumChunks()), cache.Hits())
+}
+
+// synthesizeNumberedFiles creates nfiles files, where the i-th file
+// contains 10*i distinct records in chunks of about 4 records.
+func synthesizeNumberedFiles(nfiles, compressor int) (dir string, files, records []string, e error) {
+	dir, e = ioutil.TempDir("", "recordio-numbered-test")
+	if e != nil {
+		return "", nil, nil, e
+	}
+
+	for i := 0; i < nfiles; i++ {
+		fn := path.Join(dir, fmt.Sprintf("%05d.recordio", i))
+		files = append(files, fn)
+		f, e := os.Create(fn)
+		if e != nil {
+			return "", nil, nil, e
+		}
+		w := NewWriter(f, 50, compressor)
+		for j := 0; j < 10*i; j++ { // The first file is empty.
+			r := fmt.Sprintf("record-%d-%02d", i, j)
+			if _, e := w.Write([]byte(r)); e != nil {
+				return "", nil, nil, e
+			}
+			records = append(records, r)
+		}
+		if e := w.Close(); e != nil {
+			return "", nil, nil, e
+		}
+	}
+	return dir, files, records, nil
+}
+
+func TestParallelFileListScanner(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, all, e := synthesizeNumberedFiles(5, Gzip)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+	a.Equal(len(all), fl.TotalRecords())
+
+	scan := func(start, len int, opts ...ScanOption) []string {
+		scnr := NewFileListScanner(fl, start, len, opts...)
+		var got []string
+		for r := range scnr.Chan() {
+			got = append(got, string(r))
+		}
+		a.NoError(scnr.Error())
+		return got
+	}
+
+	for _, r := range [][2]int{{0, 100}, {13, 47}, {35, 1}, {99, 10}} {
+		end := r[0] + r[1]
+		if end > len(all) {
+			end = len(all)
+		}
+		want := all[r[0]:end]
+		a.Equal(want, scan(r[0], r[1]))
+		a.Equal(want, scan(r[0], r[1], Workers(4), ReadAhead(3)))
+
+		got := scan(r[0], r[1], Workers(4), Ordered(false))
+		sort.Strings(got)
+		a.Equal(want, got)
+	}
+}
+
+func TestFileListScannerWithOptions(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, e := synthesizeFiles()
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+
+	count := func(scnr *FileListScanner) int {
+		n := 0
+		for range scnr.Chan() {
+			n++
+		}
+		return n
+	}
+
+	scnr := NewFileListScannerWithOptions(fl, -1, -1, ScanOptions{})
+	a.Equal(defaultBufferSize, cap(scnr.Chan()))
+	a.Equal(fl.TotalRecords(), count(scnr))
+	a.NoError(scnr.Error())
+
+	scnr = NewFileListScannerWithOptions(fl, 10, 20, ScanOptions{
+		BufferSize: 1,
+		Workers:    3,
+	})
+	a.Equal(1, cap(scnr.Chan()))
+	a.Equal(3, scnr.opts.Workers)
+	a.Equal(6, scnr.opts.ReadAhead)
+	a.Equal(20, count(scnr))
+
+	// Functional options override the struct.
+	scnr = NewFileListScannerWithOptions(fl, -1, -1, ScanOptions{BufferSize: 1}, BufferSize(7))
+	a.Equal(7, cap(scnr.Chan()))
+	a.Equal(fl.TotalRecords(), count(scnr))
+
+	// A canceled context stops the scanner.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	scnr = NewFileListScannerWithOptions(fl, -1, -1, ScanOptions{Context: ctx})
+	a.Equal(0, count(scnr))
+
+	// Like NewFileListScanner, a zero len means no record.
+	scnr = NewFileListScannerWithOptions(fl, 10, 0, ScanOptions{})
+	a.Equal(0, count(scnr))
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, all, e := synthesizeNumberedFiles(4, NoCompression)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	o := &openFiles{max: 2}
+	f1, e := o.open(1, files[1])
+	a.NoError(e)
+	f2, e := o.open(2, files[2])
+	a.NoError(e)
+	f, e := o.open(1, files[1])
+	a.NoError(e)
+	a.Equal(f1, f)
+	_, e = o.open(3, files[3]) // closes f2, the least recently used.
+	a.NoError(e)
+	_, e = f2.Stat()
+	a.Error(e)
+	_, e = f1.Stat()
+	a.NoError(e)
+	o.close()
+	_, e = f1.Stat()
+	a.Error(e)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+	var got []string
+	for r := range NewFileListScanner(fl, -1, -1, Shuffle(1, 2), MaxOpenFiles(3)).Chan() {
+		got = append(got, string(r))
+	}
+	sort.Strings(got)
+	a.Equal(all, got)
+}
+
+// goroutinesBack waits a while for the number of goroutines to drop
+// to n, and returns false if it doesn't.
+func goroutinesBack(n int) bool {
+	for i := 0; i < 100; i++ {
+		if runtime.NumGoroutine() <= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestFileListScannerClose(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, e := synthesizeFiles()
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+
+	n := runtime.NumGoroutine()
+
+	// The consumer stops reading after one record.
+	scnr := NewFileListScanner(fl, -1, -1, BufferSize(1), Workers(4))
+	<-scnr.Chan()
+	a.NoError(scnr.Close())
+	a.NoError(scnr.Close())
+	a.Equal(ErrStopped, scnr.Error())
+	a.True(goroutinesBack(n))
+
+	// Closing a complete scanner is fine.  Records sent to Chan don't
+	// go to Records.
+	scnr = NewFileListScanner(fl, 0, 10)
+	m := 0
+	for range scnr.Chan() {
+		m++
+	}
+	a.Equal(10, m)
+	_, ok := <-scnr.Records()
+	a.False(ok)
+	a.NoError(scnr.Close())
+	a.True(goroutinesBack(n))
+
+	// So is closing a scanner before calling Chan or Records.
+	scnr = NewFileListScanner(fl, -1, -1, BufferSize(1))
+	a.NoError(scnr.Close())
+	a.True(goroutinesBack(n))
+
+	// Canceling the context stops scanning.
+	ctx, cancel := context.WithCancel(context.Background())
+	scnr = NewFileListScannerContext(ctx, fl, -1, -1, BufferSize(1))
+	<-scnr.Chan()
+	cancel()
+	m = 1
+	for range scnr.Chan() {
+		m++
+	}
+	a.True(m < fl.TotalRecords())
+	a.Equal(context.Canceled, scnr.Close())
+	a.True(goroutinesBack(n))
+}
+
+func TestFileListScannerErrorRace(t *testing.T) {
+	a := assert.New(t)
+
+	f, e := ioutil.TempFile("", "recordio-error-race")
+	a.NoError(e)
+	defer os.Remove(f.Name())
+
+	w := NewWriter(f, 10, Gzip)
+	for i := 0; i < 100; i++ {
+		_, e := w.Write([]byte(fmt.Sprintf("record-%d", i)))
+		a.NoError(e)
+	}
+	a.NoError(w.Close()) // closes f.
+
+	data, e := ioutil.ReadFile(f.Name())
+	a.NoError(e)
+	idx, e := LoadIndex(bytes.NewReader(data))
+	a.NoError(e)
+	data[idx.chunkOffsets[50]+20] ^= 0x10
+	a.NoError(ioutil.WriteFile(f.Name(), data, 0644))
+
+	fl, e := NewFileList([]string{f.Name()})
+	a.NoError(e)
+	scnr := NewFileListScanner(fl, -1, -1, BufferSize(1), Workers(4))
+
+	// Polling Error while scanning is safe.
+	polled := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			scnr.Error()
+		}
+		close(polled)
+	}()
+
+	n := 0
+	for range scnr.Chan() {
+		n++
+	}
+	<-polled
+	a.Equal(50, n)
+	a.Equal(ErrCorruptHeader, scnr.Error()) // final once Chan is closed.
+
+	// The position of a scanner skipping the chunk passes it.
+	scnr = NewFileListScanner(fl, -1, -1, SkipCorrupt(nil), Ordered(false), Workers(4))
+	n = 0
+	for range scnr.Chan() {
+		n++
+	}
+	a.NoError(scnr.Error())
+	a.Equal(99, n)
+	a.Equal(100, scnr.Position().Offset)
+}
+
+func TestShards(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, e := synthesizeFiles()
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+	total := fl.TotalRecords()
+	chunks := 0
+	for _, idx := range fl.indices {
+		chunks += idx.NumChunks()
+	}
+
+	a.Nil(fl.Shards(0))
+	a.Nil(fl.Shards(-1))
+	a.Nil(fl.ChunkAlignedShards(0))
+	for _, c := range [][2]int{{0, 0}, {0, -1}, {-1, 4}, {4, 4}, {5, 4}} {
+		a.Equal(Range{}, fl.Shard(c[0], c[1]), "Shard(%d, %d)", c[0], c[1])
+	}
+	for _, n := range []int{1, 4, 7, total, total + 3} {
+		rs := fl.Shards(n)
+		a.Equal(n, len(rs))
+		start := 0
+		for i, r := range rs {
+			a.Equal(start, r.Start)
+			a.True(r.Len == total/n || r.Len == total/n+1)
+			a.Equal(r, fl.Shard(i, n))
+			start += r.Len
+		}
+		a.Equal(total, start)
+
+		rs = fl.ChunkAlignedShards(n)
+		a.Equal(n, len(rs))
+		cache := NewChunkCache(1 << 20)
+		start = 0
+		for _, r := range rs {
+			a.Equal(start, r.Start)
+			start += r.Len
+			if r.Len == 0 {
+				continue
+			}
+			_, _, record := fl.Locate(r.Start)
+			a.Equal(0, record) // at a chunk boundary.
+
+			m := 0
+			scnr := NewFileListScanner(fl, r.Start, r.Len, WithChunkCache(cache))
+			for range scnr.Chan() {
+				m++
+			}
+			a.NoError(scnr.Error())
+			a.Equal(r.Len, m)
+		}
+		a.Equal(total, start)
+		a.Equal(int64(0), cache.Hits()) // No chunk is decoded twice.
+		a.Equal(int64(chunks), cache.Misses())
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, all, e := synthesizeNumberedFiles(5, Snappy)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+
+	scan := func(start, len int, opts ...ScanOption) []string {
+		scnr := NewFileListScanner(fl, start, len, opts...)
+		var got []string
+		for r := range scnr.Chan() {
+			got = append(got, string(r))
+		}
+		a.NoError(scnr.Error())
+		return got
+	}
+
+	got := scan(-1, -1, Shuffle(1, 3))
+	a.NotEqual(all, got)
+	a.Equal(got, scan(-1, -1, Shuffle(1, 3), Workers(4), Ordered(false)))
+	a.NotEqual(got, scan(-1, -1, Shuffle(2, 3)))
+	a.NotEqual(got, scan(-1, -1, Shuffle(1, 3), ResumeAt(1, 0)))
+	sorted := append([]string(nil), got...)
+	sort.Strings(sorted)
+	a.Equal(all, sorted)
+
+	// A range is shuffled within itself.
+	part := scan(13, 50, Shuffle(1, 2))
+	sort.Strings(part)
+	a.Equal(all[13:63], part)
+
+	// Resuming gives the rest of the same order.
+	for _, offset := range []int{0, 1, 7, 12, 50, 99, 100, 120} {
+		rest := scan(-1, -1, Shuffle(1, 3), ResumeAt(0, offset))
+		if offset >= len(got) {
+			a.Empty(rest)
+			continue
+		}
+		a.Equal(got[offset:], rest, "offset %d", offset)
+	}
+	a.Equal(all[42:], scan(-1, -1, ResumeAt(0, 42)))
+
+	// Resuming doesn't read windows before the offset.
+	cache := NewChunkCache(1 << 20)
+	a.Equal(got[99:], scan(-1, -1, Shuffle(1, 3), ResumeAt(0, 99), WithChunkCache(cache)))
+	a.True(cache.Misses() <= 3)
+}
+
+func TestMixer(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, all, e := synthesizeNumberedFiles(5, Snappy)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+	big, e := NewFileList(files) // 100 records.
+	a.NoError(e)
+	small, e := NewFileList(files[:3]) // 30 records.
+	a.NoError(e)
+
+	sources := []MixSource{{FileList: big, Weight: 7}, {FileList: small, Weight: 3}}
+	mix := func(seed int64, policy MixPolicy, n int) (recs [2][]string, order []int) {
+		m, e := NewMixer(sources, seed, policy, BufferSize(1))
+		a.NoError(e)
+		defer m.Close()
+		for len(order) < n && m.Scan() {
+			recs[m.Source()] = append(recs[m.Source()], string(m.Record()))
+			order = append(order, m.Source())
+		}
+		return recs, order
+	}
+
+	// MixStop stops when the small source runs out.
+	recs, order := mix(1, MixStop, maxInt)
+	a.Equal(all[:30], recs[1])
+	a.Equal(all[:len(recs[0])], recs[0])
+	a.True(len(recs[0]) > 30)
+	_, order2 := mix(1, MixStop, maxInt)
+	a.Equal(order, order2)
+	_, order2 = mix(2, MixStop, maxInt)
+	a.NotEqual(order, order2)
+
+	// MixRepeat keeps the weights.
+	n := runtime.NumGoroutine()
+	recs, _ = mix(1, MixRepeat, 2000)
+	a.InDelta(0.7, float64(len(recs[0]))/2000, 0.05)
+	a.Equal(all[:30], recs[1][30:60])
+	a.True(goroutinesBack(n))
+
+	_, e = NewMixer([]MixSource{{FileList: big}}, 1, MixStop)
+	a.Error(e)
+	empty, e := NewFileList(files[:1])
+	a.NoError(e)
+	m, e := NewMixer([]MixSource{{FileList: empty, Weight: 1}}, 1, MixRepeat)
+	a.NoError(e)
+	a.False(m.Scan())
+	a.Error(m.Error())
+	a.NoError(m.Close())
+
+	// Close returns the error of a source.
+	a.NoError(os.Remove(files[4]))
+	m, e = NewMixer([]MixSource{{FileList: big, Weight: 1}}, 1, MixStop)
+	a.NoError(e)
+	for m.Scan() {
+	}
+	a.Error(m.Error())
+	a.NotEqual(io.EOF, m.Error())
+	a.Equal(m.Error(), m.Close())
+}
+
+func TestEpochs(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, all, e := synthesizeNumberedFiles(5, Snappy)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+
+	records := func(opts ...ScanOption) (rs []Record) {
+		scnr := NewFileListScanner(fl, 10, 80, opts...)
+		for r := range scnr.Records() {
+			a.Equal(all[r.Index], string(r.Data))
+			rs = append(rs, r)
+		}
+		a.NoError(scnr.Error())
+		return rs
+	}
+
+	rs := records(Epochs(3))
+	a.Equal(240, len(rs))
+	for i, r := range rs {
+		a.Equal(i/80, r.Epoch)
+		a.Equal(10+i%80, r.Index)
+	}
+
+	// Each epoch is shuffled differently.
+	rs = records(Epochs(2), Shuffle(1, 2))
+	a.Equal(160, len(rs))
+	var orders [2][]int
+	for _, r := range rs {
+		orders[r.Epoch] = append(orders[r.Epoch], r.Index)
+	}
+	a.NotEqual(orders[0], orders[1])
+	sort.Ints(orders[1])
+	a.Equal(10, orders[1][0])
+	a.Equal(89, orders[1][79])
+
+	// Resuming continues with later epochs.
+	resumed := records(Epochs(2), Shuffle(1, 2), ResumeAt(0, 30))
+	a.Equal(rs[30:], resumed)
+	a.Equal(rs[80:], records(Epochs(2), Shuffle(1, 2), ResumeAt(1, 0)))
+
+	// Negative epochs and offsets fail instead of panicking.
+	for _, o := range []ScanOption{ResumeAt(0, -3), ResumeAt(-1, 0)} {
+		for _, s := range []ScanOption{Shuffle(1, 2), Epochs(1)} {
+			scnr := NewFileListScanner(fl, -1, -1, s, o)
+			for range scnr.Chan() {
+			}
+			a.Error(scnr.Error())
+			a.Error(scnr.Close())
+		}
+	}
+
+	// Repeat forever until Close.
+	n := runtime.NumGoroutine()
+	scnr := NewFileListScanner(fl, -1, -1, Epochs(-1), BufferSize(1))
+	m := 0
+	for range scnr.Chan() {
+		if m++; m == 3*fl.TotalRecords() {
+			break
+		}
+	}
+	a.NoError(scnr.Close())
+	a.True(goroutinesBack(n))
+}
+
+func TestFileListScannerPosition(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, _, e := synthesizeNumberedFiles(5, Snappy)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+
+	drain := func(scnr *FileListScanner) (rs []Record) {
+		for r := range scnr.Records() {
+			rs = append(rs, r)
+		}
+		a.NoError(scnr.Error())
+		return rs
+	}
+
+	for _, shuffle := range []bool{false, true} {
+		opts := []ScanOption{Epochs(2)}
+		if shuffle {
+			opts = append(opts, Shuffle(7, 2))
+		}
+		scnr := NewFileListScanner(fl, 5, 90, opts...)
+		all := drain(scnr)
+		a.Equal(180, len(all))
+
+		for _, k := range []int{-1, 0, 41, 89, 90, 150, 179} {
+			p := NewFileListScanner(fl, 5, 90, opts...).Position()
+			if k >= 0 {
+				p = scnr.PositionAfter(all[k])
+			}
+			b, e := json.Marshal(p)
+			a.NoError(e)
+			var q Position
+			a.NoError(json.Unmarshal(b, &q))
+			a.Equal(p, q)
+
+			resumed, e := ResumeFileListScanner(fl, q, Epochs(2))
+			a.NoError(e)
+			rest := drain(resumed)
+			if k == 179 {
+				a.Empty(rest)
+			} else {
+				a.Equal(all[k+1:], rest, "shuffle %v, k %d", shuffle, k)
+			}
+		}
+	}
+
+	// Resuming doesn't read chunks before the position.
+	scnr := NewFileListScanner(fl, -1, -1)
+	var last Record
+	for i := 0; i < 50; i++ {
+		last = <-scnr.Records()
+	}
+	p := scnr.PositionAfter(last)
+	a.Equal(p, scnr.Position())
+	a.NoError(scnr.Close())
+	before, chunks := 0, 0
+	for i, idx := range fl.indices {
+		for _, n := range idx.accumChunkLens {
+			if fl.first(i)+n <= 50 {
+				before++
+			}
+			chunks++
+		}
+	}
+	cache := NewChunkCache(1 << 20)
+	resumed, e := ResumeFileListScanner(fl, p, WithChunkCache(cache))
+	a.NoError(e)
+	a.Equal(50, len(drain(resumed)))
+	a.Equal(int64(chunks-before), cache.Misses())
+
+	other, e := NewFileList(files[:4])
+	a.NoError(e)
+	_, e = ResumeFileListScanner(other, NewFileListScanner(fl, -1, -1).Position())
+	a.Equal(ErrPositionMismatch, e)
+
+	// Files of other sizes don't match either.
+	resized := *fl
+	resized.sizes = append([]int64{fl.sizes[0] + 1}, fl.sizes[1:]...)
+	_, e = ResumeFileListScanner(&resized, p)
+	a.Equal(ErrPositionMismatch, e)
+}
+
+func TestFileListScannerChanPosition(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, all, e := synthesizeNumberedFiles(5, Snappy)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+
+	resume := func(p Position) []string {
+		rs := []string{}
+		scnr, e := ResumeFileListScanner(fl, p)
+		a.NoError(e)
+		for r := range scnr.Chan() {
+			rs = append(rs, string(r))
+		}
+		return rs
+	}
+
+	// The position is exact if the channel never fills up.
+	for _, k := range []int{0, 1, 37, 100} {
+		scnr := NewFileListScanner(fl, -1, -1, BufferSize(200))
+		for i := 0; i < k; i++ {
+			<-scnr.Chan()
+		}
+		p := scnr.Position()
+		a.NoError(scnr.Close())
+		a.Equal(k, p.Offset)
+		a.Equal(all[k:], resume(p))
+	}
+
+	// Resuming an unordered scanner repeats records received out of
+	// order, but doesn't skip any.
+	cache := NewChunkCache(1 << 20)
+	warm := NewFileListScanner(fl, 10, -1, WithChunkCache(cache))
+	for range warm.Chan() {
+	}
+	for _, k := range []int{4, 50} {
+		scnr := NewFileListScanner(fl, -1, -1, Ordered(false), WithChunkCache(cache), BufferSize(200))
+		seen := make(map[string]bool)
+		for i := 0; i < k; i++ {
+			seen[string(<-scnr.Chan())] = true
+		}
+		p := scnr.Position()
+		a.NoError(scnr.Close())
+		a.True(p.Offset <= k)
+		for _, r := range resume(p) {
+			seen[r] = true
+		}
+		a.Equal(len(all), len(seen))
+	}
+
+	// Otherwise, resuming repeats at most the last record received.
+	for _, k := range []int{1, 37, 99} {
+		scnr := NewFileListScanner(fl, -1, -1, BufferSize(1))
+		for i := 0; i < k; i++ {
+			<-scnr.Chan()
+		}
+		p := scnr.Position()
+		a.NoError(scnr.Close())
+		a.True(p.Offset == k || p.Offset == k-1, "%d after %d", p.Offset, k)
+		a.Equal(all[p.Offset:], resume(p))
+	}
+}
+
+func TestResumeInvalidPosition(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, _, e := synthesizeNumberedFiles(3, Snappy)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+	fl, e := NewFileList(files)
+	a.NoError(e)
+
+	valid := NewFileListScanner(fl, 5, 3).Position()
+	_, e = ResumeFileListScanner(fl, valid)
+	a.NoError(e)
+	for _, f := range []func(p *Position){
+		func(p *Position) { p.File = 7 },
+		func(p *Position) { p.File = -2 },
+		func(p *Position) { p.Chunk = -1 },
+		func(p *Position) { p.Chunk = 100 },
+		func(p *Position) { p.Record = 100 },
+		func(p *Position) { p.File, p.Chunk, p.Record = 2, 0, 0 }, // after End.
+		func(p *Position) { p.Offset = -1 },
+		func(p *Position) { p.Offset = 4 },
+		func(p *Position) { p.Epoch = -1 },
+		func(p *Position) { p.Start = -1 },
+		func(p *Position) { p.End = 4 },
+		func(p *Position) { p.End = 31 },
+		func(p *Position) { p.Shuffled = true },
+	} {
+		p := valid
+		f(&p)
+		_, e := ResumeFileListScanner(fl, p)
+		a.Equal(ErrInvalidPosition, e, "%+v", p)
+	}
+
+	file, e := os.Open(files[2])
+	a.NoError(e)
+	defer file.Close()
+	valid = NewScanner(file, fl.indices[2], 5, 10).Position()
+	_, e = ResumeScanner(file, fl.indices[2], valid)
+	a.NoError(e)
+	for _, f := range []func(p *Position){
+		func(p *Position) { p.File = 1 },
+		func(p *Position) { p.Chunk = 100 },
+		func(p *Position) { p.Chunk, p.Record = 0, 0 }, // before Start.
+		func(p *Position) { p.End = 21 },
+	} {
+		p := valid
+		f(&p)
+		_, e := ResumeScanner(file, fl.indices[2], p)
+		a.Equal(ErrInvalidPosition, e, "%+v", p)
+	}
+}
+
+func TestFileListInputs(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, _, e := synthesizeNumberedFiles(4, NoCompression)
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+	a.NoError(WriteIndexFile(files[1], fl.indices[1]))
+
+	// Overlapping patterns in any order give the same sorted files.
+	g, e := NewFileListFromGlob(path.Join(dir, "*3.recordio"), path.Join(dir, "*.recordio"))
+	a.NoError(e)
+	a.Equal(files, g.files)
+	_, e = NewFileListFromGlob(path.Join(dir, "*.none"))
+	a.Error(e)
+
+	// Paths are cleaned before sorting.
+	g, e = NewFileListFromGlob(path.Join(dir, "..", path.Base(dir), "*3.recordio"), path.Join(dir, "*.recordio"))
+	a.NoError(e)
+	a.Equal(files, g.files)
+
+	// Index files, temporary ones, and other files not matching the
+	// pattern are not data files.
+	a.NoError(ioutil.WriteFile(IndexFileName(files[2])+"-123", nil, 0644))
+	d, e := NewFileListFromDir(dir, "*")
+	a.NoError(e)
+	a.Equal(files, d.files)
+	a.Equal(fl.TotalRecords(), d.TotalRecords())
+	_, e = NewFileListFromDir(dir, "[")
+	a.Error(e)
+
+	mf := path.Join(dir, "manifest")
+	a.NoError(WriteManifest(mf, fl))
+	d, e = NewFileListFromDir(dir, "*.recordio")
+	a.NoError(e)
+	a.Equal(files, d.files)
+	_, e = NewFileListFromDir(dir, "*")
+	a.Error(e) // The manifest is not a RecordIO file.
+	b, e := ioutil.ReadFile(mf)
+	a.NoError(e)
+	a.Equal("0 00000.recordio\n10 00001.recordio\n20 00002.recordio\n30 00003.recordio\n", string(b))
+
+	// Lines in any order, comments, and absolute paths.
+	a.NoError(ioutil.WriteFile(mf, []byte(fmt.Sprintf(
+		"# numbered\n30 00003.recordio\n\n10 %s\n0 00000.recordio\n20 00002.recordio\n", files[1])), 0644))
+	m, e := NewFileListFromManifest(mf)
+	a.NoError(e)
+	a.Equal(files, m.files)
+	a.Equal(fl.Fingerprint(), m.Fingerprint())
+
+	// The manifest spares indexing files not scanned.
+	a.Equal(make([]*Index, len(files)), m.indices)
+	scnr := NewFileListScanner(m, 30, 20)
+	n := 0
+	for range scnr.Chan() {
+		n++
+	}
+	a.NoError(scnr.Error())
+	a.Equal(20, n)
+	a.Equal([]*Index{nil, nil, nil, fl.indices[3]}, m.indices)
+
+	// Files with other numbers of records fail to scan.
+	a.NoError(ioutil.WriteFile(mf, []byte("0 00000.recordio\n11 00001.recordio\n"), 0644))
+	m, e = NewFileListFromManifest(mf)
+	a.NoError(e)
+	scnr = NewFileListScanner(m, -1, -1)
+	for range scnr.Chan() {
+	}
+	a.Error(scnr.Error())
+	a.NoError(ioutil.WriteFile(mf, []byte("-1 00000.recordio\n"), 0644))
+	_, e = NewFileListFromManifest(mf)
+	a.Error(e)
+	a.NoError(ioutil.WriteFile(mf, []byte("00000.recordio\n"), 0644))
+	_, e = NewFileListFromManifest(mf)
+	a.Error(e)
+}
@@ -0,0 +1,209 @@
+package recordio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Writer.Close appends an index footer after the last chunk, so
+// LoadIndex could load the index with a few reads from the end of
+// the file instead of seeking through every chunk header.  The
+// footer consists of the index and a fixed-size trailer:
+//
+//	index:   magic | numChunks | (offset uint64, numRecords uint32) * numChunks
+//	trailer: offset of index uint64 | CRC32 of index | magic
+//
+// The index begins with footerMagicNumber in place of a chunk
+// header, so readers that scan chunks sequentially find it there.
+// Offsets are relative to where the Writer started, so if files are
+// concatenated, only the last footer is loaded, and scanning skips
+// the others.
+const (
+	footerMagicNumber uint32 = 0x01020306
+	indexEntrySize           = 12 // sizeof(offset + numRecords)
+	indexHeaderSize          = 8  // sizeof(magic + numChunks)
+	trailerSize              = 16
+)
+
+// encodeIndex returns the binary form of idx used by the footer.
+func encodeIndex(idx *Index) []byte {
+	buf := make([]byte, indexHeaderSize+indexEntrySize*idx.NumChunks())
+	binary.LittleEndian.PutUint32(buf[0:4], footerMagicNumber)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(idx.NumChunks()))
+	p := buf[indexHeaderSize:]
+	for i := range idx.chunkOffsets {
+		binary.LittleEndian.PutUint64(p[0:8], uint64(idx.chunkOffsets[i]))
+		binary.LittleEndian.PutUint32(p[8:12], uint32(idx.chunkRecords[i]))
+		p = p[indexEntrySize:]
+	}
+	return buf
+}
+
+// decodeIndex parses the output of encodeIndex.
+func decodeIndex(buf []byte) (*Index, error) {
+	if len(buf) < indexHeaderSize ||
+		binary.LittleEndian.Uint32(buf[0:4]) != footerMagicNumber {
+		return nil, fmt.Errorf("Failed to parse index magic number")
+	}
+
+	n := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if len(buf) != indexHeaderSize+indexEntrySize*n {
+		return nil, fmt.Errorf("Index of %d chunks has wrong size %d", n, len(buf))
+	}
+
+	offsets := make([]int64, n)
+	records := make([]int, n)
+	p := buf[indexHeaderSize:]
+	for i := 0; i < n; i++ {
+		offsets[i] = int64(binary.LittleEndian.Uint64(p[0:8]))
+		records[i] = int(binary.LittleEndian.Uint32(p[8:12]))
+		p = p[indexEntrySize:]
+	}
+	return newIndex(offsets, records), nil
+}
+
+// writeFooter writes the index footer of a file whose index
+// starts at the given offset.
+func writeFooter(w io.Writer, idx *Index, offset int64) error {
+	body := encodeIndex(idx)
+	var trailer [trailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[0:8], uint64(offset))
+	binary.LittleEndian.PutUint32(trailer[8:12], crc32.ChecksumIEEE(body))
+	binary.LittleEndian.PutUint32(trailer[12:16], footerMagicNumber)
+
+	if _, e := w.Write(body); e != nil {
+		return fmt.Errorf("Failed to write index footer: %v", e)
+	}
+	if _, e := w.Write(trailer[:]); e != nil {
+		return fmt.Errorf("Failed to write index trailer: %v", e)
+	}
+	return nil
+}
+
+// errNoFooter means that a file was written without an index
+// footer, or that the footer is damaged.
+var errNoFooter = errors.New("No valid index footer")
+
+// readFooter loads the index from the footer of r.  It returns
+// errNoFooter if r has no valid footer.
+func readFooter(r io.ReadSeeker) (*Index, error) {
+	size, e := r.Seek(0, io.SeekEnd)
+	if e != nil {
+		return nil, e
+	}
+	if size < indexHeaderSize+trailerSize {
+		return nil, errNoFooter
+	}
+
+	var trailer [trailerSize]byte
+	if _, e := r.Seek(size-trailerSize, io.SeekStart); e != nil {
+		return nil, e
+	}
+	if _, e := io.ReadFull(r, trailer[:]); e != nil {
+		return nil, e
+	}
+	if binary.LittleEndian.Uint32(trailer[12:16]) != footerMagicNumber {
+		return nil, errNoFooter
+	}
+
+	offset := int64(binary.LittleEndian.Uint64(trailer[0:8]))
+	if offset < 0 || offset > size-trailerSize-indexHeaderSize {
+		return nil, errNoFooter
+	}
+	body := make([]byte, size-trailerSize-offset)
+	if _, e := r.Seek(offset, io.SeekStart); e != nil {
+		return nil, e
+	}
+	if _, e := io.ReadFull(r, body); e != nil {
+		return nil, e
+	}
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(trailer[8:12]) {
+		return nil, errNoFooter
+	}
+
+	idx, e := decodeIndex(body)
+	if e != nil {
+		return nil, errNoFooter
+	}
+	return idx, nil
+}
+
+// dataEnd returns where chunks in r end, i.e., the offset of the index
+// footer, or the size of r if there is no footer.
+func dataEnd(r io.ReadSeeker) (int64, error) {
+	size, e := r.Seek(0, io.SeekEnd)
+	if e != nil {
+		return 0, e
+	}
+	if offset, ok := footerOffset(r, size); ok {
+		return offset, nil
+	}
+	return size, nil
+}
+
+// footerOffset returns the offset of the index footer recorded in the
+// trailer of r, whose size is size, if there is a trailer and the
+// index there has the right size.  It doesn't verify the checksum.
+func footerOffset(r io.ReadSeeker, size int64) (int64, bool) {
+	if size < indexHeaderSize+trailerSize {
+		return 0, false
+	}
+	var trailer [trailerSize]byte
+	if _, e := r.Seek(size-trailerSize, io.SeekStart); e != nil {
+		return 0, false
+	}
+	if _, e := io.ReadFull(r, trailer[:]); e != nil {
+		return 0, false
+	}
+	offset := int64(binary.LittleEndian.Uint64(trailer[0:8]))
+	if binary.LittleEndian.Uint32(trailer[12:16]) != footerMagicNumber ||
+		offset < 0 || offset > size-trailerSize-indexHeaderSize {
+		return 0, false
+	}
+	var head [indexHeaderSize]byte
+	if _, e := r.Seek(offset, io.SeekStart); e != nil {
+		return 0, false
+	}
+	if _, e := io.ReadFull(r, head[:]); e != nil {
+		return 0, false
+	}
+	n := int64(binary.LittleEndian.Uint32(head[4:8]))
+	if binary.LittleEndian.Uint32(head[0:4]) != footerMagicNumber ||
+		offset+indexHeaderSize+indexEntrySize*n+trailerSize != size {
+		return 0, false
+	}
+	return offset, true
+}
+
+// footerEnd returns the end of the valid index footer at offset of r,
+// e.g., one in the middle of concatenated files.
+func footerEnd(r io.ReadSeeker, offset int64) (int64, bool) {
+	var head [indexHeaderSize]byte
+	if _, e := r.Seek(offset, io.SeekStart); e != nil {
+		return 0, false
+	}
+	if _, e := io.ReadFull(r, head[:]); e != nil ||
+		binary.LittleEndian.Uint32(head[0:4]) != footerMagicNumber {
+		return 0, false
+	}
+
+	// Don't trust numChunks to allocate the index at once.
+	chksum := crc32.NewIEEE()
+	chksum.Write(head[:])
+	n := int64(binary.LittleEndian.Uint32(head[4:8]))
+	if _, e := io.CopyN(chksum, r, indexEntrySize*n); e != nil {
+		return 0, false
+	}
+	var trailer [trailerSize]byte
+	if _, e := io.ReadFull(r, trailer[:]); e != nil {
+		return 0, false
+	}
+	if binary.LittleEndian.Uint32(trailer[12:16]) != footerMagicNumber ||
+		binary.LittleEndian.Uint32(trailer[8:12]) != chksum.Sum32() {
+		return 0, false
+	}
+	return offset + indexHeaderSize + indexEntrySize*n + trailerSize, true
+}
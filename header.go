@@ -2,8 +2,11 @@ package recordio
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"math"
 )
 
 const (
@@ -17,44 +20,195 @@ const (
 	// Gzip is a well-known compression algorithm.  It is
 	// recommmended only you are looking for compression ratio.
 	Gzip
+	// Zstd compresses almost as well as Gzip and decompresses
+	// several times faster.  Levels range from 1 to 22.
+	Zstd
+	// LZ4 trades compression ratio for very fast decompression.
+	// Levels range from 0 (fast) to 9.
+	LZ4
 
-	magicNumber       uint32 = 0x01020304
-	defaultCompressor        = Snappy
-	headerSize               = 20 // sizeof(struct header + magic number)
+	defaultCompressor = Snappy
 )
 
+// A chunk header has one of the following layouts, in little endian:
+//
+//	v1: magic | checkSum | compressor | compressedSize | numRecords
+//	v2: magicV2 | version | flags | headerLen | checkSum | compressor |
+//	    compressedSize | numRecords | extension | headerCheckSum
+//
+// All fields are uint32 except that version and flags are uint8 and
+// headerLen, the total size of the v2 header, is uint16.  If flags
+// has flagWide, compressedSize is uint64 and so is the length
+// prefix of each record in the chunk data, which is uint32
+// otherwise.  If flags has flagHeaderChecksum, the header ends with
+// the CRC32 checksum of all its preceding bytes.  Writer writes v2
+// headers with checksums.  A later version could append fields to
+// the extension; readers skip those they don't know about.
+const (
+	magicNumber   uint32 = 0x01020304
+	magicNumberV2 uint32 = 0x01020305
+
+	headerVersion    = 2  // the latest version supported.
+	headerSize       = 20 // size of v1 header, including the magic number.
+	headerV2Size     = 24 // size of v2 header, excluding the extension and checksum.
+	headerV2WideSize = 28 // size of v2 header with flagWide.
+	headerV2Fixed    = 8  // size of magic, version, flags and headerLen.
+
+	flagWide           uint8 = 1 << 0 // 64-bit chunk size and record lengths.
+	flagHeaderChecksum uint8 = 1 << 1 // header ends with its CRC32.
+	knownFlags               = flagWide | flagHeaderChecksum
+)
+
+// ErrCorruptHeader is returned when a chunk header fails the
+// checksum or is otherwise malformed.
+var ErrCorruptHeader = errors.New("Corrupted chunk header")
+
+// UnsupportedVersionError is returned when a chunk header is newer
+// than the ones this package understands.
+type UnsupportedVersionError uint8
+
+func (e UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("Unsupported chunk header version %d, expecting at most %d",
+		uint8(e), headerVersion)
+}
+
 // header is the metadata of Chunk.
 type header struct {
 	checkSum       uint32
 	compressor     uint32
-	compressedSize uint32
+	compressedSize uint64
 	numRecords     uint32
+	flags          uint8 // always 0 in v1 headers; never has flagHeaderChecksum.
 }
 
+// wide returns true if the chunk uses 64-bit sizes.
+func (c *header) wide() bool {
+	return c.flags&flagWide != 0
+}
+
+// write writes the header in the latest layout.
 func (c *header) write(w io.Writer) (int, error) {
-	var buf [headerSize]byte
-	binary.LittleEndian.PutUint32(buf[0:4], magicNumber)
-	binary.LittleEndian.PutUint32(buf[4:8], c.checkSum)
-	binary.LittleEndian.PutUint32(buf[8:12], c.compressor)
-	binary.LittleEndian.PutUint32(buf[12:16], c.compressedSize)
-	binary.LittleEndian.PutUint32(buf[16:20], c.numRecords)
-	return w.Write(buf[:])
+	var buf [headerV2WideSize + 4]byte
+	binary.LittleEndian.PutUint32(buf[0:4], magicNumberV2)
+	buf[4] = headerVersion
+	buf[5] = c.flags | flagHeaderChecksum
+	binary.LittleEndian.PutUint32(buf[8:12], c.checkSum)
+	binary.LittleEndian.PutUint32(buf[12:16], c.compressor)
+
+	n := headerV2Size
+	if c.wide() {
+		n = headerV2WideSize
+		binary.LittleEndian.PutUint64(buf[16:24], c.compressedSize)
+		binary.LittleEndian.PutUint32(buf[24:28], c.numRecords)
+	} else {
+		if c.compressedSize > math.MaxUint32 {
+			return 0, fmt.Errorf("Chunk size %d requires 64-bit header", c.compressedSize)
+		}
+		binary.LittleEndian.PutUint32(buf[16:20], uint32(c.compressedSize))
+		binary.LittleEndian.PutUint32(buf[20:24], c.numRecords)
+	}
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(n+4))
+	binary.LittleEndian.PutUint32(buf[n:n+4], crc32.ChecksumIEEE(buf[:n]))
+	return w.Write(buf[:n+4])
 }
 
+// parseHeader reads a v1 or v2 header.  It returns io.EOF at the end
+// of the file or at the index footer, and ErrCorruptHeader if the
+// magic number or the header checksum is wrong.
 func parseHeader(r io.Reader) (*header, error) {
 	var buf [headerSize]byte
-	if _, e := r.Read(buf[:]); e != nil {
+	if _, e := io.ReadFull(r, buf[0:4]); e != nil {
 		return nil, e
 	}
 
-	if v := binary.LittleEndian.Uint32(buf[0:4]); v != magicNumber {
-		return nil, fmt.Errorf("Failed to parse magic number")
+	switch binary.LittleEndian.Uint32(buf[0:4]) {
+	case magicNumber:
+		if _, e := io.ReadFull(r, buf[4:headerSize]); e != nil {
+			return nil, unexpectedEOF(e)
+		}
+		return &header{
+			checkSum:       binary.LittleEndian.Uint32(buf[4:8]),
+			compressor:     binary.LittleEndian.Uint32(buf[8:12]),
+			compressedSize: uint64(binary.LittleEndian.Uint32(buf[12:16])),
+			numRecords:     binary.LittleEndian.Uint32(buf[16:20]),
+		}, nil
+
+	case magicNumberV2:
+		return parseHeaderV2(r)
+
+	case footerMagicNumber:
+		return nil, io.EOF // The index footer follows the last chunk.
+	}
+	return nil, ErrCorruptHeader
+}
+
+// parseHeaderV2 reads the rest of a v2 header after the magic number.
+func parseHeaderV2(r io.Reader) (*header, error) {
+	buf := make([]byte, headerV2Fixed)
+	binary.LittleEndian.PutUint32(buf[0:4], magicNumberV2)
+	if _, e := io.ReadFull(r, buf[4:]); e != nil {
+		return nil, unexpectedEOF(e)
+	}
+
+	hdrLen := int(binary.LittleEndian.Uint16(buf[6:8]))
+	if hdrLen < headerV2Size {
+		return nil, ErrCorruptHeader
+	}
+	buf = append(buf, make([]byte, hdrLen-headerV2Fixed)...)
+	if _, e := io.ReadFull(r, buf[headerV2Fixed:]); e != nil {
+		return nil, unexpectedEOF(e)
 	}
 
-	return &header{
-		checkSum:       binary.LittleEndian.Uint32(buf[4:8]),
-		compressor:     binary.LittleEndian.Uint32(buf[8:12]),
-		compressedSize: binary.LittleEndian.Uint32(buf[12:16]),
-		numRecords:     binary.LittleEndian.Uint32(buf[16:20]),
-	}, nil
+	// Check the checksum before trusting the version and flags.
+	version, flags := buf[4], buf[5]
+	minLen := headerV2Size
+	if flags&flagHeaderChecksum != 0 {
+		minLen += 4
+		if hdrLen < minLen ||
+			crc32.ChecksumIEEE(buf[:hdrLen-4]) != binary.LittleEndian.Uint32(buf[hdrLen-4:]) {
+			return nil, ErrCorruptHeader
+		}
+	}
+	if version > headerVersion {
+		return nil, UnsupportedVersionError(version)
+	}
+	if version < 2 {
+		return nil, ErrCorruptHeader
+	}
+	if flags&^knownFlags != 0 {
+		return nil, fmt.Errorf("Unsupported chunk header flags %#x", flags)
+	}
+	if flags&flagWide != 0 {
+		minLen += headerV2WideSize - headerV2Size
+	}
+	if hdrLen < minLen {
+		return nil, ErrCorruptHeader
+	}
+
+	hdr := &header{
+		checkSum:   binary.LittleEndian.Uint32(buf[8:12]),
+		compressor: binary.LittleEndian.Uint32(buf[12:16]),
+		flags:      flags &^ flagHeaderChecksum,
+	}
+	if hdr.wide() {
+		hdr.compressedSize = binary.LittleEndian.Uint64(buf[16:24])
+		hdr.numRecords = binary.LittleEndian.Uint32(buf[24:28])
+		if hdr.compressedSize > math.MaxInt64 {
+			return nil, ErrCorruptHeader
+		}
+	} else {
+		hdr.compressedSize = uint64(binary.LittleEndian.Uint32(buf[16:20]))
+		hdr.numRecords = binary.LittleEndian.Uint32(buf[20:24])
+	}
+	return hdr, nil
+}
+
+// unexpectedEOF converts io.EOF in the middle of a header into
+// io.ErrUnexpectedEOF, so callers don't take a truncated header as
+// the end of the file.
+func unexpectedEOF(e error) error {
+	if e == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return e
 }
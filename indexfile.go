@@ -0,0 +1,143 @@
+package recordio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrStaleIndex is returned by ReadIndexFile if the data file has
+// changed since the index file was written.
+var ErrStaleIndex = errors.New("Index file does not match the data file")
+
+// WriteTo writes the index in the binary form read by ReadIndex.
+// It implements io.WriterTo.
+func (r *Index) WriteTo(w io.Writer) (int64, error) {
+	buf := encodeIndex(r)
+	var chksum [4]byte
+	binary.LittleEndian.PutUint32(chksum[:], crc32.ChecksumIEEE(buf))
+
+	n, e := w.Write(buf)
+	if e != nil {
+		return int64(n), e
+	}
+	m, e := w.Write(chksum[:])
+	return int64(n + m), e
+}
+
+// ReadIndex reads an index written by Index.WriteTo.
+func ReadIndex(r io.Reader) (*Index, error) {
+	hdr := make([]byte, indexHeaderSize)
+	if _, e := io.ReadFull(r, hdr); e != nil {
+		return nil, fmt.Errorf("Failed to read index header: %v", e)
+	}
+	if binary.LittleEndian.Uint32(hdr[0:4]) != footerMagicNumber {
+		return nil, fmt.Errorf("Failed to parse index magic number")
+	}
+
+	// Don't trust n to allocate the buffer at once, so a corrupted
+	// index fails at the end of r instead of exhausting the memory.
+	n := int64(binary.LittleEndian.Uint32(hdr[4:8]))
+	buf := bytes.NewBuffer(hdr)
+	if _, e := io.CopyN(buf, r, indexEntrySize*n+4); e != nil {
+		return nil, fmt.Errorf("Failed to read index of %d chunks: %v", n, unexpectedEOF(e))
+	}
+
+	body, chksum := buf.Bytes()[:buf.Len()-4], buf.Bytes()[buf.Len()-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(chksum) {
+		return nil, fmt.Errorf("Index checksum checking failed")
+	}
+	return decodeIndex(body)
+}
+
+// IndexFileName returns the name of the index file of a data file,
+// which is the data file name with suffix ".idx".
+func IndexFileName(fn string) string {
+	return fn + ".idx"
+}
+
+// An index file starts with the size and the modification time of
+// the data file, followed by the output of Index.WriteTo.
+const indexFileHeaderSize = 16
+
+// WriteIndexFile saves the index of data file fn into the index
+// file beside it.  NewFileList uses the index file, if it is up to
+// date, instead of loading the index from the data file.
+func WriteIndexFile(fn string, idx *Index) error {
+	fi, e := os.Stat(fn)
+	if e != nil {
+		return e
+	}
+
+	// Write to a temporary file and rename it, so concurrent readers
+	// never see a partial index file.
+	f, e := ioutil.TempFile(filepath.Dir(fn), filepath.Base(fn)+".idx-")
+	if e != nil {
+		return e
+	}
+	defer os.Remove(f.Name()) // No-op after a successful rename.
+
+	var hdr [indexFileHeaderSize]byte
+	binary.LittleEndian.PutUint64(hdr[0:8], uint64(fi.Size()))
+	binary.LittleEndian.PutUint64(hdr[8:16], uint64(fi.ModTime().UnixNano()))
+	if _, e := f.Write(hdr[:]); e != nil {
+		f.Close()
+		return fmt.Errorf("Failed to write index file %s: %v", f.Name(), e)
+	}
+	if _, e := idx.WriteTo(f); e != nil {
+		f.Close()
+		return fmt.Errorf("Failed to write index file %s: %v", f.Name(), e)
+	}
+	if e := f.Close(); e != nil {
+		return e
+	}
+	return os.Rename(f.Name(), IndexFileName(fn))
+}
+
+// ReadIndexFile loads the index of data file fn from the index file
+// beside it.  It returns ErrStaleIndex if the size or the
+// modification time of fn differs from the ones recorded in the
+// index file.
+func ReadIndexFile(fn string) (*Index, error) {
+	fi, e := os.Stat(fn)
+	if e != nil {
+		return nil, e
+	}
+
+	f, e := os.Open(IndexFileName(fn))
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	var hdr [indexFileHeaderSize]byte
+	if _, e := io.ReadFull(f, hdr[:]); e != nil {
+		return nil, fmt.Errorf("Failed to read index file %s: %v", f.Name(), e)
+	}
+	if int64(binary.LittleEndian.Uint64(hdr[0:8])) != fi.Size() ||
+		int64(binary.LittleEndian.Uint64(hdr[8:16])) != fi.ModTime().UnixNano() {
+		return nil, ErrStaleIndex
+	}
+	return ReadIndex(f)
+}
+
+// loadIndex reads the index file of fn if it is up to date, or
+// loads the index from fn otherwise.
+func loadIndex(fn string, o *ScanOptions) (*Index, error) {
+	if idx, e := ReadIndexFile(fn); e == nil {
+		return idx, nil
+	}
+
+	f, e := os.Open(fn)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	return loadIndexFrom(f, o)
+}
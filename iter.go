@@ -0,0 +1,39 @@
+//go:build go1.23
+// +build go1.23
+
+package recordio
+
+import "iter"
+
+// All returns an iterator over the remaining records and their
+// indices in the file.  Check Error after the loop; it is io.EOF if
+// the scanner reached the end of its range.
+func (s *Scanner) All() iter.Seq2[int, []byte] {
+	return func(yield func(int, []byte) bool) {
+		for s.Scan() {
+			if !yield(s.cur, s.Record()) {
+				return
+			}
+		}
+	}
+}
+
+// Records returns an iterator over records in the range [start,
+// start+len) of fl, like NewFileListScanner.  If scanning fails, the
+// last iteration yields a nil record and the error.  Breaking out of
+// the loop stops the background goroutines and closes the file.
+func (fl *FileList) Records(start, len int, opts ...ScanOption) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		scnr := NewFileListScanner(fl, start, len, opts...)
+		defer scnr.Close()
+
+		for r := range scnr.Chan() {
+			if !yield(r, nil) {
+				return
+			}
+		}
+		if e := scnr.Error(); e != nil {
+			yield(nil, e)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//go:build go1.23
+// +build go1.23
+
+package recordio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerAll(t *testing.T) {
+	a := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 30, Snappy)
+	for i := 0; i < 100; i++ {
+		_, e := w.Write([]byte(fmt.Sprintf("%03d", i)))
+		a.NoError(e)
+	}
+	a.NoError(w.Close())
+
+	idx, e := LoadIndex(bytes.NewReader(buf.Bytes()))
+	a.NoError(e)
+
+	s := NewScanner(bytes.NewReader(buf.Bytes()), idx, 10, 20)
+	n := 0
+	for i, r := range s.All() {
+		a.Equal(10+n, i)
+		a.Equal(fmt.Sprintf("%03d", i), string(r))
+		n++
+	}
+	a.Equal(20, n)
+	a.Equal(io.EOF, s.Error())
+}
+
+func TestFileListRecords(t *testing.T) {
+	a := assert.New(t)
+
+	dir, files, e := synthesizeFiles()
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+
+	fl, e := NewFileList(files)
+	a.NoError(e)
+
+	n := 0
+	for r, e := range fl.Records(-1, -1) {
+		a.NoError(e)
+		a.NotNil(r)
+		n++
+	}
+	a.Equal(fl.TotalRecords(), n)
+
+	// Breaking out of the loop stops the scanner.
+	g := runtime.NumGoroutine()
+	for _, e := range fl.Records(-1, -1, BufferSize(1)) {
+		a.NoError(e)
+		break
+	}
+	a.True(goroutinesBack(g))
+
+	// Errors come last.
+	os.Remove(files[5])
+	var last error
+	n = 0
+	for _, e := range fl.Records(-1, -1) {
+		last = e
+		n++
+	}
+	a.True(os.IsNotExist(last))
+	a.Equal(fl.accumFileLens[4]+1, n)
+}
@@ -0,0 +1,172 @@
+package recordio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wangkuiyi/parallel"
+)
+
+// NewFileListFromGlob creates a FileList of files matching any of the
+// patterns, in the syntax of filepath.Match.  Files are sorted by
+// their absolute paths, so all workers agree on the record numbering.
+func NewFileListFromGlob(patterns ...string) (*FileList, error) {
+	seen := make(map[string]bool)
+	var fns []string
+	for _, p := range patterns {
+		m, e := filepath.Glob(p)
+		if e != nil {
+			return nil, fmt.Errorf("Bad pattern %s: %v", p, e)
+		}
+		for _, fn := range m {
+			if fn, e = filepath.Abs(fn); e != nil {
+				return nil, e
+			}
+			if !seen[fn] {
+				seen[fn] = true
+				fns = append(fns, fn)
+			}
+		}
+	}
+	if len(fns) == 0 {
+		return nil, fmt.Errorf("No file matches %v", patterns)
+	}
+	sort.Strings(fns)
+	return NewFileList(fns)
+}
+
+// NewFileListFromDir creates a FileList of regular files in dir whose
+// names match pattern, in the syntax of filepath.Match, sorted by
+// their names.  A pattern like "*.recordio" excludes other files,
+// e.g., manifests.  It skips hidden files, and index files written by
+// WriteIndexFile, even if they match.
+func NewFileListFromDir(dir, pattern string) (*FileList, error) {
+	if _, e := filepath.Match(pattern, ""); e != nil {
+		return nil, fmt.Errorf("Bad pattern %s: %v", pattern, e)
+	}
+	infos, e := ioutil.ReadDir(dir) // sorted by names.
+	if e != nil {
+		return nil, e
+	}
+	var fns []string
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() ||
+			strings.HasPrefix(fi.Name(), ".") ||
+			isIndexFile(fi.Name()) {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, fi.Name()); ok {
+			fns = append(fns, filepath.Join(dir, fi.Name()))
+		}
+	}
+	if len(fns) == 0 {
+		return nil, fmt.Errorf("No file matches %s in %s", pattern, dir)
+	}
+	return NewFileList(fns)
+}
+
+// isIndexFile returns true if fn is an index file, or a temporary file
+// written by WriteIndexFile.
+func isIndexFile(fn string) bool {
+	return strings.HasSuffix(fn, IndexFileName("")) || strings.Contains(fn, IndexFileName("")+"-")
+}
+
+// WriteManifest writes the paths and the number of records of files
+// in fl into the manifest file fn, one file per line:
+//
+//	<number of records> <path>
+//
+// Paths are relative to the directory of fn if possible.  Lines
+// starting with # are comments.
+func WriteManifest(fn string, fl *FileList) error {
+	base, e := filepath.Abs(filepath.Dir(fn))
+	if e != nil {
+		return e
+	}
+
+	var buf bytes.Buffer
+	for i, f := range fl.files {
+		if abs, e := filepath.Abs(f); e == nil {
+			if rel, e := filepath.Rel(base, abs); e == nil && !strings.HasPrefix(rel, "..") {
+				f = rel
+			}
+		}
+		fmt.Fprintf(&buf, "%d %s\n", fl.numRecords(i), filepath.ToSlash(f))
+	}
+	return ioutil.WriteFile(fn, buf.Bytes(), 0644)
+}
+
+// NewFileListFromManifest creates a FileList of files listed in the
+// manifest file fn, sorted by their absolute paths.  Relative paths
+// are relative to the directory of fn.  It takes the numbers of
+// records from the manifest, and loads the index of a file only when
+// scanning it, which fails if the number of records of the file
+// differs from that in the manifest.
+func NewFileListFromManifest(fn string) (*FileList, error) {
+	f, e := os.Open(fn)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	counts := make(map[string]int)
+	var fns []string
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		fields := strings.SplitN(l, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expecting <records> <path>", fn, line)
+		}
+		n, e := strconv.Atoi(fields[0])
+		if e != nil {
+			return nil, fmt.Errorf("%s:%d: bad number of records: %v", fn, line, e)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%s:%d: negative number of records %d", fn, line, n)
+		}
+		p := filepath.FromSlash(strings.TrimSpace(fields[1]))
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(fn), p)
+		}
+		if p, e = filepath.Abs(p); e != nil {
+			return nil, e
+		}
+		if _, ok := counts[p]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicated file %s", fn, line, p)
+		}
+		counts[p] = n
+		fns = append(fns, p)
+	}
+	if e := s.Err(); e != nil {
+		return nil, e
+	}
+
+	sort.Strings(fns)
+	sizes := make([]int64, len(fns))
+	if e := parallel.For(0, len(fns), 1, func(i int) error {
+		fi, e := os.Stat(fns[i])
+		if e != nil {
+			return e
+		}
+		sizes[i] = fi.Size()
+		return nil
+	}); e != nil {
+		return nil, e
+	}
+	ns := make([]int, len(fns))
+	for i, p := range fns {
+		ns[i] = counts[p]
+	}
+	return newFileList(fns, make([]*Index, len(fns)), sizes, ns), nil
+}
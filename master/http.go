@@ -0,0 +1,146 @@
+package master
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// The HTTP API.  All requests are POST.  /task returns a JSON Task,
+// or status 204 for ErrNoTask, or 410 for ErrDone.  /finish and /fail
+// take a JSON Task, and return status 409 for ErrStaleTask.
+const (
+	pathTask   = "/task"
+	pathFinish = "/finish"
+	pathFail   = "/fail"
+)
+
+// ServeHTTP serves the HTTP API of m.
+func (m *Master) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case pathTask:
+		t, e := m.GetTask()
+		switch e {
+		case nil:
+			b, e := json.Marshal(t)
+			if e != nil {
+				http.Error(w, e.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if _, e := w.Write(b); e != nil {
+				log.Printf("Failed to send task %d: %v", t.ID, e)
+			}
+		case ErrNoTask:
+			w.WriteHeader(http.StatusNoContent)
+		case ErrDone:
+			http.Error(w, e.Error(), http.StatusGone)
+		default:
+			http.Error(w, e.Error(), http.StatusInternalServerError)
+		}
+
+	case pathFinish, pathFail:
+		var t Task
+		if e := json.NewDecoder(r.Body).Decode(&t); e != nil {
+			http.Error(w, e.Error(), http.StatusBadRequest)
+			return
+		}
+		var e error
+		if r.URL.Path == pathFinish {
+			e = m.TaskFinished(t)
+		} else {
+			e = m.TaskFailed(t)
+		}
+		switch e {
+		case nil:
+		case ErrStaleTask:
+			http.Error(w, e.Error(), http.StatusConflict)
+		default:
+			http.Error(w, e.Error(), http.StatusInternalServerError)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Client calls the HTTP API of a Master.
+type Client struct {
+	addr string
+	http *http.Client
+}
+
+// ClientTimeout is the timeout of requests sent by clients created
+// by NewClient.
+const ClientTimeout = time.Minute
+
+// NewClient creates a client of the master serving at addr, e.g.,
+// "http://localhost:8080".
+func NewClient(addr string) *Client {
+	return NewClientWithHTTP(addr, &http.Client{Timeout: ClientTimeout})
+}
+
+// NewClientWithHTTP is like NewClient, but sends requests using hc,
+// which should have a timeout, so a hung master doesn't block workers
+// forever.
+func NewClientWithHTTP(addr string, hc *http.Client) *Client {
+	return &Client{addr: addr, http: hc}
+}
+
+// GetTask leases a task.  Like Master.GetTask, it returns ErrNoTask
+// or ErrDone if there is no task to lease.
+func (c *Client) GetTask() (Task, error) {
+	var t Task
+	e := c.post(pathTask, nil, &t)
+	return t, e
+}
+
+// TaskFinished reports t done.
+func (c *Client) TaskFinished(t Task) error {
+	return c.post(pathFinish, t, nil)
+}
+
+// TaskFailed reports t failed.
+func (c *Client) TaskFailed(t Task) error {
+	return c.post(pathFail, t, nil)
+}
+
+func (c *Client) post(path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if e := json.NewEncoder(&body).Encode(in); e != nil {
+			return e
+		}
+	}
+
+	resp, e := c.http.Post(c.addr+path, "application/json", &body)
+	if e != nil {
+		return e
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	case http.StatusNoContent:
+		return ErrNoTask
+	case http.StatusGone:
+		return ErrDone
+	case http.StatusConflict:
+		return ErrStaleTask
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("Master returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+}
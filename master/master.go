@@ -0,0 +1,296 @@
+// Package master dispatches tasks, i.e., ranges of records in a
+// recordio.FileList, to elastic workers for dynamic sharding.  A
+// worker leases a task, scans its records, and reports it finished or
+// failed.  Tasks whose leases time out go back to the queue, so
+// workers could come and go at any time.
+package master
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wangkuiyi/recordio"
+)
+
+var (
+	// ErrNoTask means all remaining tasks are leased.  Retry later.
+	ErrNoTask = errors.New("No task available now")
+	// ErrDone means all tasks of all epochs are done.
+	ErrDone = errors.New("All tasks are done")
+	// ErrStaleTask means the task is not leased, e.g., because the
+	// lease timed out and the task was given to another worker.
+	ErrStaleTask = errors.New("Task is not leased or its lease expired")
+)
+
+// Task is a range of records leased to a worker.
+type Task struct {
+	ID    int `json:"id"`
+	Epoch int `json:"epoch"`
+	Lease int `json:"lease"` // distinguishes leases of the same task.
+	Start int `json:"start"`
+	Len   int `json:"len"`
+}
+
+// Config configures a Master.  The zero value means the defaults.
+type Config struct {
+	TaskSize    int           // records per task, aligned to chunks; 1000 by default.
+	Timeout     time.Duration // lease timeout; 10 minutes by default.
+	MaxFailures int           // failures, including timeouts, before dropping a task; 3 by default.
+	Epochs      int           // passes over the file list; 1 by default.
+	Snapshot    string        // if not empty, the file to save and restore the state.
+}
+
+// Master hands out tasks of a FileList.  It is safe for concurrent
+// use.
+type Master struct {
+	c     Config
+	tasks []recordio.Range // indexed by task ID.
+	now   func() time.Time // for testing.
+	mu    sync.Mutex
+	state state
+}
+
+// state is what a Master saves in its snapshot.
+type state struct {
+	Files     string        `json:"files"` // the fingerprint of the file list.
+	Total     int           `json:"total"` // records, to detect a changed file list.
+	NumTasks  int           `json:"num_tasks"`
+	Epoch     int           `json:"epoch"`
+	Todo      []int         `json:"todo"` // IDs of tasks to lease.
+	Pending   map[int]lease `json:"pending"`
+	Failures  map[int]int   `json:"failures"`
+	Dropped   []int         `json:"dropped"` // IDs of tasks failed too many times.
+	NextLease int           `json:"next_lease"`
+}
+
+type lease struct {
+	Lease    int       `json:"lease"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// New creates a Master of fl.  If c.Snapshot exists, the Master
+// resumes from it, and tasks leased before the restart are leased
+// again.
+func New(fl *recordio.FileList, c Config) (*Master, error) {
+	if c.TaskSize <= 0 {
+		c.TaskSize = 1000
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Minute
+	}
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = 3
+	}
+	if c.Epochs <= 0 {
+		c.Epochs = 1
+	}
+
+	n := (fl.TotalRecords() + c.TaskSize - 1) / c.TaskSize
+	m := &Master{c: c, now: time.Now}
+	for _, r := range fl.ChunkAlignedShards(n) {
+		if r.Len > 0 {
+			m.tasks = append(m.tasks, r)
+		}
+	}
+
+	m.state = state{
+		Files:    fl.Fingerprint(),
+		Total:    fl.TotalRecords(),
+		NumTasks: len(m.tasks),
+		Pending:  make(map[int]lease),
+		Failures: make(map[int]int),
+	}
+	m.state.Todo = allTasks(len(m.tasks))
+
+	if c.Snapshot == "" {
+		return m, nil
+	}
+	if e := m.restore(); e != nil && !os.IsNotExist(e) {
+		return nil, e
+	}
+	return m, nil
+}
+
+func allTasks(n int) []int {
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = i
+	}
+	return ids
+}
+
+// GetTask leases a task.  It returns ErrNoTask if all remaining tasks
+// of the current epoch are leased, and ErrDone if all are done.
+func (m *Master) GetTask() (Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := &m.state
+	m.expire()
+	for len(s.Todo) == 0 {
+		if len(s.Pending) > 0 {
+			return Task{}, ErrNoTask
+		}
+		if s.Epoch+1 >= m.c.Epochs {
+			return Task{}, ErrDone
+		}
+		s.Epoch++
+		s.Todo = allTasks(len(m.tasks))
+		s.Failures = make(map[int]int)
+		s.Dropped = nil
+	}
+
+	id := s.Todo[0]
+	s.Todo = s.Todo[1:]
+	s.NextLease++
+	s.Pending[id] = lease{Lease: s.NextLease, Deadline: m.now().Add(m.c.Timeout)}
+	if e := m.save(); e != nil {
+		// Undo the lease, so the task isn't lost.
+		delete(s.Pending, id)
+		s.Todo = append([]int{id}, s.Todo...)
+		s.NextLease--
+		return Task{}, e
+	}
+
+	r := m.tasks[id]
+	return Task{ID: id, Epoch: s.Epoch, Lease: s.NextLease, Start: r.Start, Len: r.Len}, nil
+}
+
+// TaskFinished marks a leased task done.
+func (m *Master) TaskFinished(t Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e := m.release(t); e != nil {
+		return e
+	}
+	return m.save()
+}
+
+// TaskFailed returns a leased task to the queue, or drops it if it
+// has failed too many times in the current epoch.
+func (m *Master) TaskFailed(t Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e := m.release(t); e != nil {
+		return e
+	}
+	m.fail(t.ID)
+	return m.save()
+}
+
+// Epoch returns the current epoch, counting from 0.
+func (m *Master) Epoch() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.Epoch
+}
+
+// Dropped returns tasks of the current epoch that failed too many
+// times.
+func (m *Master) Dropped() []Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ts []Task
+	for _, id := range m.state.Dropped {
+		r := m.tasks[id]
+		ts = append(ts, Task{ID: id, Epoch: m.state.Epoch, Start: r.Start, Len: r.Len})
+	}
+	return ts
+}
+
+// release ends the lease of t.
+func (m *Master) release(t Task) error {
+	l, ok := m.state.Pending[t.ID]
+	if !ok || l.Lease != t.Lease || t.Epoch != m.state.Epoch {
+		return ErrStaleTask
+	}
+	delete(m.state.Pending, t.ID)
+	return nil
+}
+
+// expire fails tasks whose leases timed out.
+func (m *Master) expire() {
+	var ids []int
+	now := m.now()
+	for id, l := range m.state.Pending {
+		if now.After(l.Deadline) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids) // Keep the queue deterministic.
+	for _, id := range ids {
+		log.Printf("Lease of task %d timed out", id)
+		delete(m.state.Pending, id)
+		m.fail(id)
+	}
+}
+
+func (m *Master) fail(id int) {
+	s := &m.state
+	s.Failures[id]++
+	if s.Failures[id] >= m.c.MaxFailures {
+		log.Printf("Dropped task %d after %d failures", id, s.Failures[id])
+		s.Dropped = append(s.Dropped, id)
+		return
+	}
+	s.Todo = append(s.Todo, id)
+}
+
+// save writes the state into the snapshot file, if any.
+func (m *Master) save() error {
+	if m.c.Snapshot == "" {
+		return nil
+	}
+	b, e := json.Marshal(&m.state)
+	if e != nil {
+		return e
+	}
+	tmp := m.c.Snapshot + ".tmp"
+	if e := ioutil.WriteFile(tmp, b, 0644); e != nil {
+		return fmt.Errorf("Failed to write snapshot: %v", e)
+	}
+	return os.Rename(tmp, m.c.Snapshot)
+}
+
+// restore loads the state from the snapshot file.  Leased tasks are
+// put back to the front of the queue.
+func (m *Master) restore() error {
+	b, e := ioutil.ReadFile(m.c.Snapshot)
+	if e != nil {
+		return e
+	}
+	var s state
+	if e := json.Unmarshal(b, &s); e != nil {
+		return fmt.Errorf("Failed to parse snapshot %s: %v", m.c.Snapshot, e)
+	}
+	if s.Files != m.state.Files {
+		return fmt.Errorf("Snapshot %s is of other files", m.c.Snapshot)
+	}
+	if s.Total != m.state.Total || s.NumTasks != m.state.NumTasks {
+		return fmt.Errorf("Snapshot %s is of %d records in %d tasks, but not %d in %d",
+			m.c.Snapshot, s.Total, s.NumTasks, m.state.Total, m.state.NumTasks)
+	}
+
+	var leased []int
+	for id := range s.Pending {
+		leased = append(leased, id)
+	}
+	sort.Ints(leased)
+	s.Todo = append(leased, s.Todo...)
+	s.Pending = make(map[int]lease)
+	if s.Failures == nil {
+		s.Failures = make(map[int]int)
+	}
+	m.state = s
+	return nil
+}
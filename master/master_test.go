@@ -0,0 +1,230 @@
+package master
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangkuiyi/recordio"
+)
+
+// synthesizeFileList creates 3 files of 100 records in small chunks.
+func synthesizeFileList(t *testing.T, dir string) *recordio.FileList {
+	a := assert.New(t)
+
+	var files []string
+	for i := 0; i < 3; i++ {
+		fn := path.Join(dir, fmt.Sprintf("%05d.recordio", i))
+		f, e := os.Create(fn)
+		a.NoError(e)
+		w := recordio.NewWriter(f, 50, recordio.Snappy)
+		for j := 0; j < 100; j++ {
+			_, e := w.Write([]byte(fmt.Sprintf("%d-%09d", i, j)))
+			a.NoError(e)
+		}
+		a.NoError(w.Close())
+		files = append(files, fn)
+	}
+
+	fl, e := recordio.NewFileList(files)
+	a.NoError(e)
+	return fl
+}
+
+func TestDispatchOverHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	dir, e := ioutil.TempDir("", "recordio-master-test")
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+	fl := synthesizeFileList(t, dir)
+
+	m, e := New(fl, Config{TaskSize: 20, Epochs: 2})
+	a.NoError(e)
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	// Workers count records of finished tasks in each epoch.
+	var mu sync.Mutex
+	seen := make(map[[2]int]int) // {epoch, record} -> times.
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			c := NewClient(srv.URL)
+			for i := 0; ; i++ {
+				task, e := c.GetTask()
+				if e == ErrNoTask {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				if e == ErrDone {
+					return
+				}
+				a.NoError(e)
+
+				if w == 0 && i == 0 { // The first task of a worker fails.
+					a.NoError(c.TaskFailed(task))
+					continue
+				}
+				scnr := recordio.NewFileListScanner(fl, task.Start, task.Len)
+				n := 0
+				for range scnr.Chan() {
+					n++
+				}
+				a.NoError(scnr.Error())
+				a.Equal(task.Len, n)
+
+				mu.Lock()
+				for r := task.Start; r < task.Start+task.Len; r++ {
+					seen[[2]int{task.Epoch, r}]++
+				}
+				mu.Unlock()
+				a.NoError(c.TaskFinished(task))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	a.Equal(2*fl.TotalRecords(), len(seen))
+	for k, n := range seen {
+		a.Equal(1, n, "record %d of epoch %d", k[1], k[0])
+	}
+	a.Equal(1, m.Epoch())
+	a.Empty(m.Dropped())
+}
+
+func TestLeaseTimeoutAndSnapshot(t *testing.T) {
+	a := assert.New(t)
+
+	dir, e := ioutil.TempDir("", "recordio-master-test")
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+	fl := synthesizeFileList(t, dir)
+
+	now := time.Now()
+	c := Config{
+		TaskSize:    100,
+		Timeout:     time.Minute,
+		MaxFailures: 2,
+		Snapshot:    path.Join(dir, "snapshot.json"),
+	}
+	m, e := New(fl, c)
+	a.NoError(e)
+	m.now = func() time.Time { return now }
+
+	t0, e := m.GetTask()
+	a.NoError(e)
+	a.Equal(0, t0.ID)
+	t1, e := m.GetTask()
+	a.NoError(e)
+	t2, e := m.GetTask()
+	a.NoError(e)
+	_, e = m.GetTask()
+	a.Equal(ErrNoTask, e)
+	a.NoError(m.TaskFinished(t1))
+	a.Equal(ErrStaleTask, m.TaskFinished(t1))
+
+	// The lease of t0 times out, so it is leased again.
+	now = now.Add(2 * time.Minute)
+	a.NoError(m.TaskFinished(t2)) // Not expired before the next GetTask.
+	t0b, e := m.GetTask()
+	a.NoError(e)
+	a.Equal(t0.ID, t0b.ID)
+	a.NotEqual(t0.Lease, t0b.Lease)
+	a.Equal(ErrStaleTask, m.TaskFinished(t0))
+
+	// The master restarts, and t0 is leased again.
+	m, e = New(fl, c)
+	a.NoError(e)
+	m.now = func() time.Time { return now }
+	a.Equal(ErrStaleTask, m.TaskFinished(t0b))
+	t0c, e := m.GetTask()
+	a.NoError(e)
+	a.Equal(t0.ID, t0c.ID)
+
+	// The second failure drops t0.
+	a.NoError(m.TaskFailed(t0c))
+	a.Equal([]Task{{ID: t0.ID, Start: t0.Start, Len: t0.Len}}, m.Dropped())
+	_, e = m.GetTask()
+	a.Equal(ErrDone, e)
+
+	// A snapshot of a different file list is rejected.
+	c.TaskSize = 10
+	_, e = New(fl, c)
+	a.Error(e)
+
+	// So is that of other files with the same numbers of records.
+	other, e := ioutil.TempDir("", "recordio-master-test")
+	a.NoError(e)
+	defer os.RemoveAll(other)
+	synthesizeFileList(t, other)
+	var files []string
+	for i := 0; i < 3; i++ {
+		fn := path.Join(other, fmt.Sprintf("other-%d.recordio", i))
+		a.NoError(os.Rename(path.Join(other, fmt.Sprintf("%05d.recordio", i)), fn))
+		files = append(files, fn)
+	}
+	ofl, e := recordio.NewFileList(files)
+	a.NoError(e)
+	a.Equal(fl.TotalRecords(), ofl.TotalRecords())
+	c.TaskSize = 100
+	_, e = New(ofl, c)
+	a.Error(e)
+	_, e = New(fl, c)
+	a.NoError(e)
+}
+
+func TestSnapshotFailure(t *testing.T) {
+	a := assert.New(t)
+
+	dir, e := ioutil.TempDir("", "recordio-master-test")
+	a.NoError(e)
+	defer os.RemoveAll(dir)
+	fl := synthesizeFileList(t, dir)
+
+	snapshot := path.Join(dir, "snapshot.json")
+	m, e := New(fl, Config{TaskSize: 100, Snapshot: snapshot})
+	a.NoError(e)
+
+	// The snapshot can't be written, so no task is leased.
+	a.NoError(os.Mkdir(snapshot+".tmp", 0755))
+	_, e = m.GetTask()
+	a.Error(e)
+	_, e = m.GetTask()
+	a.Error(e)
+
+	// Once it can, all tasks are leased in order.
+	a.NoError(os.Remove(snapshot + ".tmp"))
+	for i := 0; i < 3; i++ {
+		task, e := m.GetTask()
+		a.NoError(e)
+		a.Equal(i, task.ID)
+		a.Equal(i+1, task.Lease)
+	}
+	_, e = m.GetTask()
+	a.Equal(ErrNoTask, e)
+}
+
+func TestClientTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	hung := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer srv.Close()
+	defer close(hung)
+
+	c := NewClientWithHTTP(srv.URL, &http.Client{Timeout: 10 * time.Millisecond})
+	_, e := c.GetTask()
+	a.Error(e)
+}
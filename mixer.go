@@ -0,0 +1,154 @@
+package recordio
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// MixSource is a FileList to mix with others and its weight.
+type MixSource struct {
+	FileList *FileList
+	Weight   float64      // relative to weights of other sources.
+	Options  []ScanOption // e.g., Shuffle, in addition to those of the Mixer.
+}
+
+// MixPolicy decides what a Mixer does when a source runs out.
+type MixPolicy int
+
+const (
+	// MixStop stops mixing when any source runs out, so all records
+	// follow the weights.
+	MixStop MixPolicy = iota
+	// MixRepeat scans the source again in the next epoch, which
+	// reshuffles it if Shuffle is set, and never stops.
+	MixRepeat
+)
+
+// Mixer interleaves records from several FileLists, picking each
+// record from a source chosen at random in proportion to the weights.
+// The sequence of sources depends only on the seed.
+type Mixer struct {
+	sources  []MixSource
+	opts     []ScanOption
+	policy   MixPolicy
+	rng      *rand.Rand
+	accum    []float64 // accumulative weights.
+	scanners []*FileListScanner
+	epochs   []int
+	fresh    []bool // the scanner has not emitted any record.
+
+	record []byte
+	source int
+	err    error
+}
+
+// NewMixer creates a Mixer of sources.  opts configure the
+// FileListScanner of each source.
+func NewMixer(sources []MixSource, seed int64, policy MixPolicy, opts ...ScanOption) (*Mixer, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("No source to mix")
+	}
+
+	m := &Mixer{
+		sources:  sources,
+		opts:     opts,
+		policy:   policy,
+		rng:      rand.New(rand.NewSource(seed)),
+		accum:    make([]float64, len(sources)),
+		scanners: make([]*FileListScanner, len(sources)),
+		epochs:   make([]int, len(sources)),
+		fresh:    make([]bool, len(sources)),
+	}
+	sum := 0.0
+	for i, s := range sources {
+		if !(s.Weight > 0) {
+			return nil, fmt.Errorf("Source %d has a non-positive weight %v", i, s.Weight)
+		}
+		sum += s.Weight
+		m.accum[i] = sum
+	}
+	for i := range sources {
+		m.scanners[i] = m.newScanner(i)
+	}
+	return m, nil
+}
+
+func (m *Mixer) newScanner(i int) *FileListScanner {
+	opts := append(append([]ScanOption(nil), m.opts...), m.sources[i].Options...)
+	if e := m.epochs[i]; e > 0 {
+		opts = append(opts, func(o *ScanOptions) { o.Epoch, o.Offset = e, 0 })
+	}
+	m.fresh[i] = true
+	return NewFileListScanner(m.sources[i].FileList, -1, -1, opts...)
+}
+
+// pick returns a source at random in proportion to the weights.
+func (m *Mixer) pick() int {
+	x := m.rng.Float64() * m.accum[len(m.accum)-1]
+	for i, a := range m.accum {
+		if x < a {
+			return i
+		}
+	}
+	return len(m.accum) - 1
+}
+
+// Scan moves to the next record.  It returns false if mixing stops,
+// in which case Error returns io.EOF if a source ran out with the
+// MixStop policy, or the error of a source.
+func (m *Mixer) Scan() bool {
+	for m.err == nil {
+		i := m.pick()
+		if r, ok := <-m.scanners[i].Chan(); ok {
+			m.record, m.source = r, i
+			m.fresh[i] = false
+			return true
+		}
+
+		if e := m.scanners[i].Error(); e != nil {
+			m.err = e
+		} else if m.policy == MixStop {
+			m.err = io.EOF
+		} else if m.fresh[i] {
+			m.err = fmt.Errorf("Source %d has no record to repeat", i)
+		} else {
+			m.scanners[i].Close()
+			m.epochs[i]++
+			m.scanners[i] = m.newScanner(i)
+		}
+	}
+	return false
+}
+
+// Record returns the current record.
+func (m *Mixer) Record() []byte {
+	return m.record
+}
+
+// Source returns the index of the source of the current record.
+func (m *Mixer) Source() int {
+	return m.source
+}
+
+// Epoch returns how many times source i has been repeated.
+func (m *Mixer) Epoch(i int) int {
+	return m.epochs[i]
+}
+
+// Error returns the error that stopped Scan.
+func (m *Mixer) Error() error {
+	return m.err
+}
+
+// Close stops scanners of all sources.  It returns the first error
+// of them, if any.
+func (m *Mixer) Close() error {
+	var err error
+	for _, s := range m.scanners {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
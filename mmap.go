@@ -0,0 +1,141 @@
+package recordio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// MmapFile is a RecordIO file mapped into the memory.  Scanners of
+// an MmapFile don't copy records of uncompressed chunks; instead,
+// their records point into the mapped file.  On systems other than
+// Linux, OpenMmap reads the whole file into the memory instead.
+type MmapFile struct {
+	name  string
+	data  []byte
+	index *Index
+	unmap func() error
+}
+
+// OpenMmap maps the RecordIO file fn into the memory and loads its
+// index.
+func OpenMmap(fn string) (*MmapFile, error) {
+	f, e := os.Open(fn)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close() // The mapping outlives the file descriptor.
+
+	data, unmap, e := mmap(f)
+	if e != nil {
+		return nil, fmt.Errorf("Failed to map %s: %v", fn, e)
+	}
+
+	idx, e := LoadIndex(bytes.NewReader(data))
+	if e != nil {
+		unmap()
+		return nil, e
+	}
+	return &MmapFile{name: fn, data: data, index: idx, unmap: unmap}, nil
+}
+
+// Name returns the name of the file passed to OpenMmap.
+func (m *MmapFile) Name() string {
+	return m.name
+}
+
+// Index returns the index of the file.
+func (m *MmapFile) Index() *Index {
+	return m.index
+}
+
+// Close unmaps the file.  Records returned by scanners of m must not
+// be accessed after Close, or the program might crash.
+func (m *MmapFile) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	e := m.unmap()
+	m.data, m.unmap = nil, nil
+	return e
+}
+
+// NewScanner creates a scanner of records in [start, start+len), like
+// NewScanner does.  The slice returned by Scanner.Record is valid
+// only until the next call to Scan, because records of compressed
+// chunks are decoded into a buffer reused by the following chunk.
+// Copy records to keep them.  The WithChunkCache option has no
+// effect, as the cache would keep records beyond their lifetime.
+func (m *MmapFile) NewScanner(start, len int, opts ...ScanOption) *Scanner {
+	s := newScanner(nil, nil, m.index, start, len, opts)
+	s.mapped = m
+	return s
+}
+
+// decodeBuffers holds buffers of decompressed chunks for reuse.
+var decodeBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// decodeChunk decodes the chunk at offset of data.  Records of an
+// uncompressed chunk point into data; otherwise, they point into buf,
+// which is overwritten.
+func decodeChunk(data []byte, offset int64, buf *bytes.Buffer) (*chunk, error) {
+	if offset < 0 || offset >= int64(len(data)) {
+		return nil, fmt.Errorf("Chunk offset %d out of range [0, %d)", offset, len(data))
+	}
+	r := bytes.NewReader(data[offset:])
+	hdr, e := parseHeader(r)
+	if e != nil {
+		return nil, e
+	}
+
+	begin := int64(len(data)) - int64(r.Len())
+	if hdr.compressedSize > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload := data[begin : begin+int64(hdr.compressedSize)]
+	return decodePayload(hdr, payload, buf)
+}
+
+// splitRecords splits decompressed chunk data into records, which
+// point into data if alias, or are copies otherwise.
+func splitRecords(data []byte, hdr *header, alias bool) (*chunk, error) {
+	n := 4
+	if hdr.wide() {
+		n = 8
+	}
+
+	// Don't trust the header to allocate records at once.
+	if uint64(hdr.numRecords) > uint64(len(data)/n) {
+		return nil, fmt.Errorf("Chunk of %d bytes can't hold %d records", len(data), hdr.numRecords)
+	}
+	ch := &chunk{records: make([][]byte, 0, hdr.numRecords)}
+	for i := 0; i < int(hdr.numRecords); i++ {
+		if len(data) < n {
+			return nil, fmt.Errorf("Failed to read record length: %v", io.ErrUnexpectedEOF)
+		}
+		var l uint64
+		if hdr.wide() {
+			l = binary.LittleEndian.Uint64(data)
+		} else {
+			l = uint64(binary.LittleEndian.Uint32(data))
+		}
+		data = data[n:]
+
+		if l > uint64(len(data)) {
+			return nil, fmt.Errorf("Failed to read a record: %v", io.ErrUnexpectedEOF)
+		}
+		r := data[:l:l]
+		if !alias {
+			r = append(make([]byte, 0, l), r...)
+		}
+		ch.records = append(ch.records, r)
+		ch.numBytes += int(l)
+		data = data[l:]
+	}
+	return ch, nil
+}
@@ -0,0 +1,29 @@
+//go:build linux
+// +build linux
+
+package recordio
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps the whole file f read-only.
+func mmap(f *os.File) ([]byte, func() error, error) {
+	st, e := f.Stat()
+	if e != nil {
+		return nil, nil, e
+	}
+	if st.Size() == 0 {
+		return nil, func() error { return nil }, nil // mmap rejects empty files.
+	}
+	if st.Size() != int64(int(st.Size())) {
+		return nil, nil, syscall.EFBIG
+	}
+
+	data, e := syscall.Mmap(int(f.Fd()), 0, int(st.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if e != nil {
+		return nil, nil, e
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package recordio
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// mmap reads the whole file f into the memory, as a fallback.
+func mmap(f *os.File) ([]byte, func() error, error) {
+	data, e := ioutil.ReadAll(f)
+	if e != nil {
+		return nil, nil, e
+	}
+	return data, func() error { return nil }, nil
+}
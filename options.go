@@ -0,0 +1,178 @@
+package recordio
+
+import "context"
+
+// defaultBufferSize is the default number of records buffered in the
+// channel of a FileListScanner.
+const defaultBufferSize = 1000
+
+// ScanOptions configures a Scanner, a FileListScanner, or a Reader.
+// The zero value means the defaults.  Options apply only to
+// FileListScanner unless noted.
+type ScanOptions struct {
+	BufferSize   int  // records buffered in the channel; 1000 by default.
+	Workers      int  // goroutines decompressing chunks; 1 by default.
+	ReadAhead    int  // chunks read ahead of emitting; 2*Workers by default.
+	Unordered    bool // emit chunks as soon as they are decoded.
+	MaxOpenFiles int  // files kept open at a time; 1 by default.
+
+	// If SkipCorrupt, scanners skip chunks failing to read, and call
+	// OnCorrupt, if not nil, for each of them.  See SkipCorrupt.
+	SkipCorrupt bool
+	OnCorrupt   func(Corruption)
+
+	// Cache is shared by scanners and readers.  See WithChunkCache.
+	Cache *ChunkCache
+
+	// Context stops the FileListScanner when done.  Nil means
+	// context.Background().
+	Context context.Context
+
+	// If Shuffle, FileListScanner emits records in an order determined
+	// by Seed and Epoch, shuffling records in windows of ShuffleWindow
+	// chunks, 16 by default.  See Shuffle.
+	Shuffle       bool
+	Seed          int64
+	ShuffleWindow int
+
+	// Epoch and Offset resume a FileListScanner.  See ResumeAt.
+	Epoch  int
+	Offset int
+
+	// Epochs is the number of passes over the range, counting from
+	// epoch 0 even if resuming at a later Epoch.  0 means one pass,
+	// and -1 repeats forever.
+	Epochs int
+}
+
+// A ScanOption sets a field of ScanOptions.
+type ScanOption func(*ScanOptions)
+
+func newScanOptions(opts []ScanOption) ScanOptions {
+	var o ScanOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.setDefaults()
+	return o
+}
+
+// setDefaults replaces zero values with the defaults.
+func (o *ScanOptions) setDefaults() {
+	if o.BufferSize < 1 {
+		o.BufferSize = defaultBufferSize
+	}
+	if o.Workers < 1 {
+		o.Workers = 1
+	}
+	if o.ReadAhead < 1 {
+		o.ReadAhead = 2 * o.Workers
+	}
+	if o.MaxOpenFiles < 1 {
+		o.MaxOpenFiles = 1
+	}
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+	if o.ShuffleWindow < 1 {
+		o.ShuffleWindow = defaultShuffleWindow
+	}
+}
+
+// BufferSize sets the number of records buffered in the channel
+// returned by FileListScanner.Chan.  The default is 1000.
+func BufferSize(n int) ScanOption {
+	return func(o *ScanOptions) { o.BufferSize = n }
+}
+
+// Workers sets the number of goroutines a FileListScanner uses to
+// decompress chunks.  The default is 1.
+func Workers(n int) ScanOption {
+	return func(o *ScanOptions) { o.Workers = n }
+}
+
+// ReadAhead sets the maximum number of chunks a FileListScanner reads
+// and decodes ahead of the chunk whose records it is emitting.  The
+// default is twice the number of workers.
+func ReadAhead(n int) ScanOption {
+	return func(o *ScanOptions) { o.ReadAhead = n }
+}
+
+// MaxOpenFiles sets the maximum number of files a FileListScanner
+// keeps open.  It closes the least recently read file to open another.
+// The default is 1, which suits scanning in order; shuffling reads
+// chunks of many files alternately, and reopens fewer files with a
+// larger limit.
+func MaxOpenFiles(n int) ScanOption {
+	return func(o *ScanOptions) { o.MaxOpenFiles = n }
+}
+
+// Ordered sets whether FileListScanner emits records in their order
+// in the file list, which is the default.  If not, it emits chunks as
+// soon as they are decoded, so a slow chunk doesn't hold back others.
+// Records within a chunk are always in order.
+func Ordered(ordered bool) ScanOption {
+	return func(o *ScanOptions) { o.Unordered = !ordered }
+}
+
+// Epochs makes FileListScanner scan the range n times, or forever if
+// n is -1.  Records carry their epochs, see FileListScanner.Records,
+// and shuffling differs in each epoch.
+func Epochs(n int) ScanOption {
+	return func(o *ScanOptions) { o.Epochs = n }
+}
+
+// WithContext makes a FileListScanner stop when ctx is done.
+func WithContext(ctx context.Context) ScanOption {
+	return func(o *ScanOptions) { o.Context = ctx }
+}
+
+// Corruption describes a chunk skipped in the SkipCorrupt mode.
+type Corruption struct {
+	File    string // empty for Scanner.
+	Offset  int64  // where the chunk starts in the file.
+	Size    int64  // bytes skipped, or -1 if unknown.
+	Records int    // records lost within the scanned range, or -1 if unknown.
+	Err     error  // why the chunk could not be read.
+}
+
+// SkipCorrupt makes scanners skip chunks that fail to read, e.g.,
+// because of checksum errors, and resume at the next chunk recorded
+// in the index, instead of stopping.  If f is not nil, it is called
+// for each skipped chunk.  FileListScanner calls f from its
+// background goroutine.  LoadIndex and NewFileList, building the
+// index of a file without a footer, skip corrupted chunk headers by
+// searching for the next magic number.
+func SkipCorrupt(f func(Corruption)) ScanOption {
+	return func(o *ScanOptions) {
+		o.SkipCorrupt = true
+		o.OnCorrupt = f
+	}
+}
+
+// cached returns the chunk if it is in the cache, or nil.
+func (o *ScanOptions) cached(file interface{}, chunk int) *chunk {
+	if o.Cache == nil {
+		return nil
+	}
+	return o.Cache.get(cacheKey{file: file, chunk: chunk})
+}
+
+// addCache adds a chunk into the cache, if any.
+func (o *ScanOptions) addCache(file interface{}, chunk int, ch *chunk) {
+	if o.Cache != nil {
+		o.Cache.put(cacheKey{file: file, chunk: chunk}, ch)
+	}
+}
+
+// corrupt reports c if SkipCorrupt is set, and returns false
+// otherwise, in which case the scanner should stop.
+func (o *ScanOptions) corrupt(c Corruption) bool {
+	if !o.SkipCorrupt {
+		return false
+	}
+	if o.OnCorrupt != nil {
+		o.OnCorrupt(c)
+	}
+	return true
+}
@@ -0,0 +1,293 @@
+package recordio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"path/filepath"
+)
+
+// ErrPositionMismatch means a Position is of other files.
+var ErrPositionMismatch = errors.New("Position is not of the files to scan")
+
+// ErrInvalidPosition means a Position of the files to scan refers to
+// records or chunks out of the range.
+var ErrInvalidPosition = errors.New("Position is out of the files to scan")
+
+// Position is where a scanner is in its range, which could be saved,
+// e.g., as JSON, and resumed by ResumeScanner or
+// ResumeFileListScanner.  It refers to the record following those
+// consumed.
+type Position struct {
+	Fingerprint string `json:"fingerprint"` // of the files and their indices.
+	Start       int    `json:"start"`       // the range of records.
+	End         int    `json:"end"`
+	Epoch       int    `json:"epoch"`
+	Offset      int    `json:"offset"` // records consumed in the epoch.
+
+	// The next record, if not shuffled.  File is -1 at the end.
+	File   int `json:"file"`
+	Chunk  int `json:"chunk"`
+	Record int `json:"record"`
+
+	// Shuffling, if Shuffled.
+	Shuffled bool  `json:"shuffled,omitempty"`
+	Seed     int64 `json:"seed,omitempty"`
+	Window   int   `json:"window,omitempty"`
+}
+
+// Position returns the position after the last record returned by
+// Record.
+func (s *Scanner) Position() Position {
+	next := s.next
+	p := Position{
+		Fingerprint: fingerprintFile(s.source(), s.readSeeker(), s.index),
+		Start:       s.start,
+		End:         s.end,
+		Offset:      next - s.start,
+		File:        -1,
+	}
+	if next < s.end {
+		p.File = 0
+		p.Chunk, p.Record = s.index.Locate(next)
+	}
+	return p
+}
+
+// ResumeScanner creates a scanner that continues from p, returned
+// by Scanner.Position of a scanner of the same file.
+func ResumeScanner(r io.ReadSeeker, index *Index, p Position, opts ...ScanOption) (*Scanner, error) {
+	if p.Fingerprint != fingerprintFile(r, r, index) {
+		return nil, ErrPositionMismatch
+	}
+	if e := p.check(index.NumRecords()); e != nil {
+		return nil, e
+	}
+	next := p.Start + p.Offset
+	if p.File > 0 {
+		return nil, ErrInvalidPosition
+	} else if p.File == 0 {
+		if e := p.checkChunk(index); e != nil {
+			return nil, e
+		}
+		next = index.first(p.Chunk) + p.Record
+	}
+	if next < p.Start || next > p.End {
+		return nil, ErrInvalidPosition
+	}
+	s := NewScanner(r, index, next, p.End-next, opts...)
+	s.start = p.Start
+	return s, nil
+}
+
+// sentRecord is the epoch and the offset of a record sent by a
+// FileListScanner.
+type sentRecord struct {
+	epoch, offset int
+}
+
+// progress tracks records consumed from a FileListScanner, which are
+// out of order if Unordered.
+type progress struct {
+	epoch, offset int                 // all records before offset in epoch are consumed.
+	ahead         map[sentRecord]bool // records after it consumed.
+}
+
+// consume marks r consumed.  Records are sent epoch by epoch, so those
+// of earlier epochs have been consumed too.
+func (p *progress) consume(r sentRecord) {
+	if r.epoch > p.epoch {
+		p.epoch, p.offset = r.epoch, 0
+		for a := range p.ahead {
+			if a.epoch < r.epoch {
+				delete(p.ahead, a)
+			}
+		}
+	}
+	p.mark(r)
+}
+
+// mark marks r consumed, and advances the offset past records
+// consumed.
+func (p *progress) mark(r sentRecord) {
+	if r.epoch < p.epoch || r.epoch == p.epoch && r.offset < p.offset {
+		return
+	}
+	if p.ahead == nil {
+		p.ahead = make(map[sentRecord]bool)
+	}
+	p.ahead[r] = true
+	for next := (sentRecord{p.epoch, p.offset}); p.ahead[next]; next.offset++ {
+		delete(p.ahead, next)
+		p.offset++
+	}
+}
+
+// consumed marks the first n records sent consumed.  mu must be held.
+func (scnr *FileListScanner) consumed(n int) {
+	for ; scnr.nconsumed < n; scnr.nconsumed++ {
+		scnr.progress.consume(scnr.sent[scnr.nconsumed%len(scnr.sent)])
+	}
+}
+
+// Position returns the position before the first record not received
+// from Chan or Records, if called by the goroutine receiving them.  It
+// never skips a record, but resuming from it repeats records received
+// out of order if Unordered, or the last record received if the
+// channel was full.  Use PositionAfter with Records to be exact.
+func (scnr *FileListScanner) Position() Position {
+	scnr.mu.Lock()
+	scnr.consumed(scnr.nsent - len(scnr.ch) - len(scnr.data))
+	epoch, offset := scnr.progress.epoch, scnr.progress.offset
+	scnr.mu.Unlock()
+	return scnr.position(epoch, offset)
+}
+
+// PositionAfter returns the position after last, a record received
+// from Records, if the scanner is ordered.
+func (scnr *FileListScanner) PositionAfter(last Record) Position {
+	return scnr.position(last.Epoch, last.Offset+1)
+}
+
+// position returns the position of the offset-th record in the epoch.
+func (scnr *FileListScanner) position(epoch, offset int) Position {
+	o := &scnr.opts
+	p := Position{
+		Fingerprint: scnr.fl.Fingerprint(),
+		Start:       scnr.start,
+		End:         scnr.end,
+		Epoch:       epoch,
+		Offset:      offset,
+		Shuffled:    o.Shuffle,
+		File:        -1,
+	}
+	if o.Shuffle {
+		p.Seed, p.Window = o.Seed, o.ShuffleWindow
+	} else if next := p.Start + p.Offset; next < p.End {
+		p.File, p.Chunk, p.Record = scnr.fl.Locate(next)
+	}
+	return p
+}
+
+// ResumeFileListScanner creates a scanner that continues from p,
+// returned by FileListScanner.Position of a scanner of the same
+// files.  If p is shuffled, the scanner shuffles the same way.  It
+// doesn't read chunks before p, except for those in the same shuffle
+// window.  opts shouldn't change the range or shuffling.
+func ResumeFileListScanner(fl *FileList, p Position, opts ...ScanOption) (*FileListScanner, error) {
+	if p.Fingerprint != fl.Fingerprint() {
+		return nil, ErrPositionMismatch
+	}
+	if e := p.check(fl.TotalRecords()); e != nil {
+		return nil, e
+	}
+	o := newScanOptions(opts)
+	o.Epoch, o.Offset = p.Epoch, p.Offset
+	if p.Shuffled {
+		o.Shuffle, o.Seed, o.ShuffleWindow = true, p.Seed, p.Window
+	} else if p.File >= len(fl.files) {
+		return nil, ErrInvalidPosition
+	} else if p.File >= 0 {
+		idx, e := fl.index(p.File)
+		if e != nil {
+			return nil, e
+		}
+		if e := p.checkChunk(idx); e != nil {
+			return nil, e
+		}
+		next := fl.first(p.File) + idx.first(p.Chunk) + p.Record
+		if next < p.Start || next > p.End {
+			return nil, ErrInvalidPosition
+		}
+		o.Offset = next - p.Start
+	}
+	return newFileListScanner(fl, p.Start, p.End-p.Start, o), nil
+}
+
+// check returns ErrInvalidPosition if the range, the epoch, the
+// offset, the file, or the shuffle window of p is invalid for files
+// of total records.
+func (p *Position) check(total int) error {
+	if p.Start < 0 || p.End < p.Start || p.End > total ||
+		p.Epoch < 0 || p.Offset < 0 || p.Offset > p.End-p.Start ||
+		p.File < -1 || p.Shuffled && p.Window < 1 {
+		return ErrInvalidPosition
+	}
+	return nil
+}
+
+// checkChunk returns ErrInvalidPosition if the chunk or the record of
+// p is not in idx.
+func (p *Position) checkChunk(idx *Index) error {
+	if p.Chunk < 0 || p.Chunk >= idx.NumChunks() ||
+		p.Record < 0 || p.Record >= idx.chunkRecords[p.Chunk] {
+		return ErrInvalidPosition
+	}
+	return nil
+}
+
+// first returns the index of the first record in the chunk.
+func (r *Index) first(chunk int) int {
+	if chunk <= 0 || chunk > r.NumChunks() {
+		return 0
+	}
+	return r.accumChunkLens[chunk-1]
+}
+
+// first returns the index of the first record in the file.
+func (fs *FileList) first(file int) int {
+	if file <= 0 || file > len(fs.accumFileLens) {
+		return 0
+	}
+	return fs.accumFileLens[file-1]
+}
+
+// Fingerprint hashes names, without directories, sizes, and numbers
+// of records of files in fs, so that workers on machines mounting the
+// files in different directories agree.  It doesn't load indices.
+func (fs *FileList) Fingerprint() string {
+	h := fnv.New64a()
+	for i, fn := range fs.files {
+		fingerprint(h, filepath.Base(fn), fs.sizes[i], fs.numRecords(i))
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// fingerprintFile returns the fingerprint of a file of index, read by
+// r, and named by src if it has a Name method, like *os.File.  Unlike
+// FileList.Fingerprint, it hashes chunks in the index too.
+func fingerprintFile(src interface{}, r io.Seeker, idx *Index) string {
+	h := fnv.New64a()
+	var b [8]byte
+	for i, o := range idx.chunkOffsets {
+		binary.LittleEndian.PutUint64(b[:], uint64(o))
+		h.Write(b[:])
+		binary.LittleEndian.PutUint64(b[:], uint64(idx.chunkRecords[i]))
+		h.Write(b[:])
+	}
+
+	name, size := "", int64(-1)
+	if f, ok := src.(interface{ Name() string }); ok {
+		name = filepath.Base(f.Name())
+	}
+	if r != nil {
+		if n, e := r.Seek(0, io.SeekEnd); e == nil {
+			size = n
+		}
+	}
+	return fingerprint(h, name, size, idx.NumRecords())
+}
+
+// fingerprint hashes the name, the size, and the number of records of
+// a file into h, and returns the hex digest.
+func fingerprint(h hash.Hash64, name string, size int64, records int) string {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[0:8], uint64(size))
+	binary.LittleEndian.PutUint64(b[8:16], uint64(records))
+	io.WriteString(h, name)
+	h.Write(b[:])
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,98 @@
+package recordio
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// recentChunks is the number of decoded chunks a Reader keeps.
+const recentChunks = 4
+
+// Reader reads records by their indices.  It is safe for concurrent
+// use if the underlying io.ReaderAt is, like *os.File.
+type Reader struct {
+	reader    io.ReaderAt
+	index     *Index
+	opts      ScanOptions
+	cacheFile interface{} // identifies the file in opts.Cache.
+
+	mu     sync.Mutex
+	recent []cachedChunk // the most recently used first.
+}
+
+type cachedChunk struct {
+	index int
+	chunk *chunk
+}
+
+// NewReader creates a Reader of the file r, whose index is index.
+// Only the WithChunkCache option applies to Reader.
+func NewReader(r io.ReaderAt, index *Index, opts ...ScanOption) *Reader {
+	rd := &Reader{reader: r, index: index, opts: newScanOptions(opts)}
+	if rd.opts.Cache != nil {
+		rd.cacheFile = cacheFile(r, index)
+	}
+	return rd
+}
+
+// Get returns the i-th record in the file.  Repeated calls for
+// records in the same chunk decode the chunk only once, as long as
+// it is among the recently used ones, or in the ChunkCache if the
+// Reader has one.
+func (r *Reader) Get(i int) ([]byte, error) {
+	ci, ri := r.index.Locate(i)
+	if ci < 0 || i < 0 {
+		return nil, fmt.Errorf("Record %d out of range [0, %d)", i, r.index.NumRecords())
+	}
+
+	if ch := r.lookup(ci); ch != nil {
+		return ch.records[ri], nil
+	}
+
+	ch, e := readChunkAt(r.reader, r.index.chunkOffsets[ci])
+	if e != nil {
+		return nil, e
+	}
+	r.add(ci, ch)
+	return ch.records[ri], nil
+}
+
+// lookup returns the chunk if it is cached, and marks it the most
+// recently used.
+func (r *Reader) lookup(ci int) *chunk {
+	if r.opts.Cache != nil {
+		return r.opts.cached(r.cacheFile, ci)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.recent {
+		if c.index == ci {
+			copy(r.recent[1:i+1], r.recent[:i])
+			r.recent[0] = c
+			return c.chunk
+		}
+	}
+	return nil
+}
+
+func (r *Reader) add(ci int, ch *chunk) {
+	if r.opts.Cache != nil {
+		r.opts.addCache(r.cacheFile, ci, ch)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.recent {
+		if c.index == ci {
+			return // Added by another goroutine.
+		}
+	}
+	if len(r.recent) < recentChunks {
+		r.recent = append(r.recent, cachedChunk{})
+	}
+	copy(r.recent[1:], r.recent)
+	r.recent[0] = cachedChunk{index: ci, chunk: ch}
+}
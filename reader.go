@@ -1,8 +1,10 @@
 package recordio
 
 import (
+	"bytes"
 	"io"
 	"log"
+	"os"
 	"sort"
 )
 
@@ -14,16 +16,74 @@ type Index struct {
 	chunkRecords   []int // the number of records in chunks.
 }
 
-// LoadIndex scans the file and parse chunkOffsets, chunkLens, and len.
-func LoadIndex(r io.ReadSeeker) (*Index, error) {
+// LoadIndex loads the index from the footer written by Writer.Close.
+// For files without a footer, it scans the file and parse chunkOffsets,
+// chunkLens, and len.  With the SkipCorrupt option, it skips chunks
+// whose headers are corrupted by searching for the next magic number
+// that begins a checksummed header or a chunk that decodes, instead of
+// failing.
+func LoadIndex(r io.ReadSeeker, opts ...ScanOption) (*Index, error) {
+	o := newScanOptions(opts)
+	return loadIndexFrom(r, &o)
+}
+
+func loadIndexFrom(r io.ReadSeeker, o *ScanOptions) (*Index, error) {
+	idx, e := readFooter(r)
+	if e != errNoFooter {
+		return idx, e
+	}
+
+	if _, e := r.Seek(0, io.SeekStart); e != nil {
+		return nil, e
+	}
+	return scanIndex(r, o)
+}
+
+// LoadIndexAt is like LoadIndex, but uses positional reads on r,
+// whose size is size, so it doesn't move the offset of a file shared
+// by other goroutines.
+func LoadIndexAt(r io.ReaderAt, size int64, opts ...ScanOption) (*Index, error) {
+	return LoadIndex(io.NewSectionReader(r, 0, size), opts...)
+}
+
+// scanIndex reads every chunk header in r to build the index.
+func scanIndex(r io.ReadSeeker, o *ScanOptions) (*Index, error) {
 	f := &Index{}
 	offset := int64(0)
 	accum := 0
-	var e error
+	size, e := r.Seek(0, io.SeekEnd)
+	if e != nil {
+		return nil, e
+	}
+	if _, e := r.Seek(0, io.SeekStart); e != nil {
+		return nil, e
+	}
 	var hdr *header
 
 	for {
 		hdr, e = parseHeader(r)
+		if e == io.EOF && offset < size {
+			// Skip footers of files concatenated together.
+			if end, ok := footerEnd(r, offset); ok {
+				offset = end
+				if _, e = r.Seek(offset, io.SeekStart); e != nil {
+					break
+				}
+				continue
+			}
+			if !isFooter(r, offset, size) {
+				e = ErrCorruptHeader // A damaged footer in a wrong place.
+			}
+		}
+		if e != nil && e != io.EOF && o.SkipCorrupt {
+			first := int64(-1)
+			if len(f.chunkOffsets) > 0 {
+				first = f.chunkOffsets[0]
+			}
+			if offset, e = skipHeader(r, offset, first, e, o); e == nil {
+				continue
+			}
+		}
 		if e != nil {
 			break
 		}
@@ -46,6 +106,46 @@ func LoadIndex(r io.ReadSeeker) (*Index, error) {
 	return nil, e
 }
 
+// skipHeader reports the corrupted chunk header at offset, which
+// failed with err, and seeks r to the next plausible chunk header.
+// first is the offset of the first chunk indexed, or -1 if none.
+func skipHeader(r io.ReadSeeker, offset, first int64, err error, o *ScanOptions) (int64, error) {
+	size, e := r.Seek(0, io.SeekEnd)
+	if e != nil {
+		return 0, e
+	}
+	// Magic numbers could appear in records, so if the file has v2
+	// headers, only accept checksummed ones.
+	checked := v2HeaderAt(r, offset) || first >= 0 && v2HeaderAt(r, first)
+	next := offset
+	for {
+		if next, e = resync(r, next+1, size); e != nil {
+			return 0, e
+		}
+		if next == size || plausibleHeader(r, next, size, checked) {
+			break
+		}
+	}
+	o.corrupt(Corruption{Offset: offset, Size: next - offset, Records: -1, Err: err})
+	_, e = r.Seek(next, io.SeekStart)
+	return next, e
+}
+
+// newIndex builds an Index from chunk offsets and the number of
+// records in each chunk.
+func newIndex(chunkOffsets []int64, chunkRecords []int) *Index {
+	f := &Index{
+		chunkOffsets:   chunkOffsets,
+		chunkRecords:   chunkRecords,
+		accumChunkLens: make([]int, len(chunkRecords)),
+	}
+	for i, n := range chunkRecords {
+		f.numRecords += n
+		f.accumChunkLens[i] = f.numRecords
+	}
+	return f
+}
+
 // NumRecords returns the total number of records in a RecordIO file.
 func (r *Index) NumRecords() int {
 	return r.numRecords
@@ -56,6 +156,23 @@ func (r *Index) NumChunks() int {
 	return len(r.accumChunkLens)
 }
 
+// chunkSize returns the size of a chunk in bytes.  The last chunk
+// ends at the index footer of f, or at the end of f if there is no
+// footer.  It returns -1 if the size is unknown.
+func (r *Index) chunkSize(chunk int, f io.ReadSeeker) int64 {
+	if chunk+1 < r.NumChunks() {
+		return r.chunkOffsets[chunk+1] - r.chunkOffsets[chunk]
+	}
+	if f == nil {
+		return -1
+	}
+	end, e := dataEnd(f)
+	if e != nil {
+		return -1
+	}
+	return end - r.chunkOffsets[chunk]
+}
+
 // Locate returns the index of chunk that contains the given record,
 // and the record index within the chunk.  It returns (-1, -1) if the
 // record is out of range.
@@ -77,17 +194,37 @@ func (r *Index) Locate(recordIndex int) (int, int) {
 // Scanner scans records in a specified range within [0, numRecords).
 type Scanner struct {
 	reader          io.ReadSeeker
+	readerAt        io.ReaderAt // if not nil, used instead of reader.
+	mapped          *MmapFile   // if not nil, used instead of readers.
+	buf             *bytes.Buffer
 	index           *Index
 	start, end, cur int
+	next            int // the record after the last one returned by Record.
 	chunkIndex      int
 	chunk           *chunk
 	err             error
+	opts            ScanOptions
+	cacheFile       interface{} // identifies the file in opts.Cache.
 }
 
 // NewScanner creates a scanner that sequencially reads records in the
 // range [start, start+len).  If start < 0, it scans from the
 // beginning.  If len < 0, it scans till the end of file.
-func NewScanner(r io.ReadSeeker, index *Index, start, len int) *Scanner {
+//
+// Scanners created by NewScanner seek r, so they must not share r
+// with each other.  Use NewScannerAt for that.
+func NewScanner(r io.ReadSeeker, index *Index, start, len int, opts ...ScanOption) *Scanner {
+	return newScanner(r, nil, index, start, len, opts)
+}
+
+// NewScannerAt is like NewScanner, but reads chunks using positional
+// reads.  Many scanners in many goroutines could share r, e.g., an
+// *os.File, as long as r supports concurrent ReadAt.
+func NewScannerAt(r io.ReaderAt, index *Index, start, len int, opts ...ScanOption) *Scanner {
+	return newScanner(nil, r, index, start, len, opts)
+}
+
+func newScanner(r io.ReadSeeker, ra io.ReaderAt, index *Index, start, len int, opts []ScanOption) *Scanner {
 	if start < 0 {
 		start = 0
 	}
@@ -95,40 +232,133 @@ func NewScanner(r io.ReadSeeker, index *Index, start, len int) *Scanner {
 		len = index.NumRecords() - start
 	}
 
-	return &Scanner{
+	s := &Scanner{
 		reader:     r,
+		readerAt:   ra,
 		index:      index,
 		start:      start,
 		end:        start + len,
 		cur:        start - 1, // The intial status required by Scan.
+		next:       start,
 		chunkIndex: -1,
 		chunk:      &chunk{},
+		opts:       newScanOptions(opts),
 	}
+	if s.opts.Cache != nil {
+		if ra != nil {
+			s.cacheFile = cacheFile(ra, index)
+		} else {
+			s.cacheFile = cacheFile(r, index)
+		}
+	}
+	return s
 }
 
 // Scan moves the cursor forward for one record and loads the chunk
-// containing the record if not yet.
+// containing the record if not yet.  In the SkipCorrupt mode, it
+// skips chunks that fail to load.
 func (s *Scanner) Scan() bool {
 	s.cur++
 
-	if s.cur >= s.end {
-		s.err = io.EOF
-	} else {
-		if ci, _ := s.index.Locate(s.cur); s.chunkIndex != ci {
-			s.chunkIndex = ci
+	for s.err == nil {
+		if s.cur >= s.end {
+			s.err = io.EOF
+			break
+		}
+
+		ci, ri := s.index.Locate(s.cur)
+		if s.chunkIndex == ci {
+			break
+		}
+
+		s.chunkIndex = ci
+		if s.mapped != nil {
+			// Records of the previous chunk are no longer valid.
+			if s.buf != nil {
+				decodeBuffers.Put(s.buf)
+			}
+			s.buf = decodeBuffers.Get().(*bytes.Buffer)
+			s.chunk, s.err = decodeChunk(s.mapped.data, s.index.chunkOffsets[ci], s.buf)
+		} else if s.chunk = s.opts.cached(s.cacheFile, ci); s.chunk != nil {
+			break
+		} else if s.readerAt != nil {
+			s.chunk, s.err = readChunkAt(s.readerAt, s.index.chunkOffsets[ci])
+		} else {
 			if _, e := s.reader.Seek(s.index.chunkOffsets[ci], io.SeekStart); e != nil {
 				log.Printf("Failed to seek chunk: %v", e)
 				return false
 			}
 			s.chunk, s.err = readChunk(s.reader)
 		}
+		if s.err == nil {
+			if s.mapped == nil {
+				s.opts.addCache(s.cacheFile, ci, s.chunk)
+			}
+			break
+		}
+
+		// Skip the rest of the chunk within the range.
+		lost := s.index.chunkRecords[ci] - ri
+		if s.cur+lost > s.end {
+			lost = s.end - s.cur
+		}
+		if s.opts.corrupt(Corruption{
+			Offset:  s.index.chunkOffsets[ci],
+			Size:    s.index.chunkSize(ci, s.readSeeker()),
+			Records: lost,
+			Err:     s.err,
+		}) {
+			s.err = nil
+			s.cur += lost
+		}
 	}
 
+	if s.err != nil && s.buf != nil {
+		decodeBuffers.Put(s.buf)
+		s.buf = nil
+	}
 	return s.err == nil
 }
 
+// source returns what the scanner reads.
+func (s *Scanner) source() interface{} {
+	if s.mapped != nil {
+		return s.mapped
+	}
+	if s.readerAt != nil {
+		return s.readerAt
+	}
+	return s.reader
+}
+
+// readSeeker returns the file being scanned as an io.ReadSeeker, or
+// nil if the size of the file is unknown.
+func (s *Scanner) readSeeker() io.ReadSeeker {
+	if s.mapped != nil {
+		return bytes.NewReader(s.mapped.data)
+	}
+	if s.readerAt == nil {
+		return s.reader
+	}
+
+	size := int64(-1)
+	switch r := s.readerAt.(type) {
+	case interface{ Size() int64 }: // e.g., *bytes.Reader.
+		size = r.Size()
+	case interface{ Stat() (os.FileInfo, error) }: // e.g., *os.File.
+		if fi, e := r.Stat(); e == nil {
+			size = fi.Size()
+		}
+	}
+	if size < 0 {
+		return nil
+	}
+	return io.NewSectionReader(s.readerAt, 0, size)
+}
+
 // Record returns the record under the current cursor.
 func (s *Scanner) Record() []byte {
+	s.next = s.cur + 1
 	_, ri := s.index.Locate(s.cur)
 	return s.chunk.records[ri]
 }
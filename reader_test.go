@@ -1,7 +1,15 @@
 package recordio
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
 	"testing"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -30,3 +38,223 @@ func TestIndexLocate(t *testing.T) {
 	assert.Equal(-1, c)
 	assert.Equal(-1, o)
 }
+
+// countingReaderAt counts calls to ReadAt.
+type countingReaderAt struct {
+	io.ReaderAt
+	n int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.n++
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func TestReaderGet(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 30, Snappy)
+	for i := 0; i < 100; i++ { // 10 chunks of 10 records.
+		_, e := w.Write([]byte(fmt.Sprintf("%03d", i)))
+		assert.NoError(e)
+	}
+	assert.NoError(w.Close())
+
+	idx, e := LoadIndex(bytes.NewReader(buf.Bytes()))
+	assert.NoError(e)
+	assert.Equal(10, idx.NumChunks())
+
+	f := &countingReaderAt{ReaderAt: bytes.NewReader(buf.Bytes())}
+	r := NewReader(f, idx)
+	for _, i := range []int{42, 7, 99, 0, 43, 41, 8} {
+		rec, e := r.Get(i)
+		assert.NoError(e)
+		assert.Equal(fmt.Sprintf("%03d", i), string(rec))
+	}
+
+	// Records in the same chunk don't read the file again.
+	n := f.n
+	for i := 40; i < 50; i++ {
+		_, e := r.Get(i)
+		assert.NoError(e)
+	}
+	assert.Equal(n, f.n)
+
+	_, e = r.Get(100)
+	assert.Error(e)
+	_, e = r.Get(-1)
+	assert.Error(e)
+}
+
+func TestScannerAtShareFile(t *testing.T) {
+	assert := assert.New(t)
+
+	f, e := ioutil.TempFile("", "recordio")
+	assert.NoError(e)
+	defer os.Remove(f.Name())
+
+	w := NewWriter(f, 30, Snappy)
+	for i := 0; i < 100; i++ {
+		_, e := w.Write([]byte(fmt.Sprintf("%03d", i)))
+		assert.NoError(e)
+	}
+	assert.NoError(w.Close()) // Closes f.
+
+	f, e = os.Open(f.Name())
+	assert.NoError(e)
+	defer f.Close()
+
+	st, e := f.Stat()
+	assert.NoError(e)
+	idx, e := LoadIndexAt(f, st.Size())
+	assert.NoError(e)
+	assert.Equal(100, idx.NumRecords())
+
+	// Scanners in many goroutines share f.
+	var wg sync.WaitGroup
+	got := make([][]string, 10)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := NewScannerAt(f, idx, i*10+5, 10)
+			for s.Scan() {
+				got[i] = append(got[i], string(s.Record()))
+			}
+			assert.Equal(io.EOF, s.Error())
+		}(i)
+	}
+	wg.Wait()
+
+	for i, recs := range got {
+		var want []string
+		for j := i*10 + 5; j < i*10+15 && j < 100; j++ {
+			want = append(want, fmt.Sprintf("%03d", j))
+		}
+		assert.Equal(want, recs)
+	}
+}
+
+func TestMmapScanner(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []int{NoCompression, Gzip} {
+		f, e := ioutil.TempFile("", "recordio")
+		assert.NoError(e)
+		defer os.Remove(f.Name())
+
+		w := NewWriter(f, 30, c)
+		for i := 0; i < 100; i++ {
+			_, e := w.Write([]byte(fmt.Sprintf("%03d", i)))
+			assert.NoError(e)
+		}
+		assert.NoError(w.Close())
+
+		m, e := OpenMmap(f.Name())
+		assert.NoError(e)
+		assert.Equal(100, m.Index().NumRecords())
+
+		begin := uintptr(unsafe.Pointer(&m.data[0]))
+		end := begin + uintptr(len(m.data))
+
+		cache := NewChunkCache(1 << 20)
+		s := m.NewScanner(15, 30, WithChunkCache(cache))
+		i := 15
+		for s.Scan() {
+			rec := s.Record()
+			assert.Equal(fmt.Sprintf("%03d", i), string(rec))
+			p := uintptr(unsafe.Pointer(&rec[0]))
+			assert.Equal(c == NoCompression, p >= begin && p < end) // zero-copy
+			i++
+		}
+		assert.Equal(io.EOF, s.Error())
+		assert.Equal(45, i)
+		assert.Nil(s.buf) // returned to the pool.
+		assert.Equal(int64(0), cache.Hits()+cache.Misses())
+		assert.NoError(m.Close())
+	}
+}
+
+func TestRecordsOwnTheirMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []int{NoCompression, Snappy} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, 100, c)
+		for i := 0; i < 2; i++ {
+			_, e := w.Write([]byte(fmt.Sprintf("%03d", i)))
+			assert.NoError(e)
+		}
+		assert.NoError(w.Close())
+
+		// Records following each other in the chunk data are not
+		// slices of it.
+		ch, e := readChunk(bytes.NewReader(buf.Bytes()))
+		assert.NoError(e)
+		p0 := uintptr(unsafe.Pointer(&ch.records[0][0]))
+		p1 := uintptr(unsafe.Pointer(&ch.records[1][0]))
+		assert.NotEqual(p0+3+4, p1)
+	}
+}
+
+func TestScannerPosition(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 30, Snappy)
+	for i := 0; i < 100; i++ {
+		_, e := w.Write([]byte(fmt.Sprintf("%03d", i)))
+		assert.NoError(e)
+	}
+	assert.NoError(w.Close())
+	idx, e := LoadIndex(bytes.NewReader(buf.Bytes()))
+	assert.NoError(e)
+
+	for _, n := range []int{0, 37, 90} {
+		s := NewScanner(bytes.NewReader(buf.Bytes()), idx, 5, 90)
+		for i := 0; i < n; i++ {
+			assert.True(s.Scan())
+			s.Record()
+		}
+		assert.Equal(n, s.Position().Offset)
+		if n < 90 {
+			assert.True(s.Scan()) // Not counted without Record.
+		}
+		b, e := json.Marshal(s.Position())
+		assert.NoError(e)
+		var p Position
+		assert.NoError(json.Unmarshal(b, &p))
+		assert.Equal(n, p.Offset)
+
+		r, e := ResumeScanner(bytes.NewReader(buf.Bytes()), idx, p)
+		assert.NoError(e)
+		i := 5 + n
+		for r.Scan() {
+			assert.Equal(fmt.Sprintf("%03d", i), string(r.Record()))
+			i++
+		}
+		assert.Equal(io.EOF, r.Error())
+		assert.Equal(95, i)
+		assert.Equal(90, r.Position().Offset)
+	}
+
+	_, e = ResumeScanner(bytes.NewReader(buf.Bytes()), newIndex([]int64{0}, []int{1}), Position{})
+	assert.Equal(ErrPositionMismatch, e)
+	p := NewScanner(bytes.NewReader(buf.Bytes()), idx, -1, -1).Position()
+	_, e = ResumeScanner(bytes.NewReader(append(buf.Bytes(), 0)), idx, p)
+	assert.Equal(ErrPositionMismatch, e)
+
+	// Records of a chunk that fails to load are not consumed.
+	data := append([]byte(nil), buf.Bytes()...)
+	data[idx.chunkOffsets[1]+20] ^= 0x10
+	s := NewScanner(bytes.NewReader(data), idx, -1, -1)
+	n := 0
+	for s.Scan() {
+		s.Record()
+		n++
+	}
+	assert.Error(s.Error())
+	assert.Equal(n, s.Position().Offset)
+	assert.Equal(idx.chunkRecords[0], n)
+}
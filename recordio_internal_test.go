@@ -2,8 +2,10 @@ package recordio
 
 import (
 	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
 	"testing"
-	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -26,6 +28,67 @@ func TestChunkHead(t *testing.T) {
 	assert.Equal(c, cc)
 }
 
+func TestParseHeaderVersions(t *testing.T) {
+	assert := assert.New(t)
+
+	// A v1 header written by earlier versions of this package.
+	v1 := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(v1[0:4], magicNumber)
+	binary.LittleEndian.PutUint32(v1[4:8], 123)
+	binary.LittleEndian.PutUint32(v1[8:12], Gzip)
+	binary.LittleEndian.PutUint32(v1[12:16], 789)
+	binary.LittleEndian.PutUint32(v1[16:20], 10)
+	hdr, e := parseHeader(bytes.NewReader(v1))
+	assert.Nil(e)
+	assert.Equal(&header{checkSum: 123, compressor: Gzip, compressedSize: 789, numRecords: 10}, hdr)
+
+	_, e = parseHeader(bytes.NewReader(v1[:10]))
+	assert.Equal(io.ErrUnexpectedEOF, e)
+
+	// A v1 chunk.
+	payload := []byte{5, 0, 0, 0, 'h', 'e', 'l', 'l', 'o'}
+	binary.LittleEndian.PutUint32(v1[4:8], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(v1[8:12], NoCompression)
+	binary.LittleEndian.PutUint32(v1[12:16], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(v1[16:20], 1)
+	ch, e := readChunk(bytes.NewReader(append(v1, payload...)))
+	assert.Nil(e)
+	assert.Equal([][]byte{[]byte("hello")}, ch.records)
+
+	// v1 headers aren't checked, so a bogus number of records fails
+	// instead of allocating them.
+	bogus := append([]byte(nil), v1...)
+	binary.LittleEndian.PutUint32(bogus[4:8], crc32.ChecksumIEEE(nil))
+	binary.LittleEndian.PutUint32(bogus[12:16], 0)
+	binary.LittleEndian.PutUint32(bogus[16:20], 0xfffffff0)
+	_, e = readChunk(bytes.NewReader(bogus))
+	assert.Error(e)
+
+	// A v2 header with an extension unknown to this version.
+	var buf bytes.Buffer
+	_, e = (&header{checkSum: 1, compressor: Snappy, compressedSize: 2, numRecords: 3}).write(&buf)
+	assert.Nil(e)
+	v2 := append(buf.Bytes()[:headerV2Size], 0xaa, 0xbb, 0xcc, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(v2[6:8], uint16(len(v2)))
+	binary.LittleEndian.PutUint32(v2[len(v2)-4:], crc32.ChecksumIEEE(v2[:len(v2)-4]))
+	r := bytes.NewReader(append(v2, 0xdd))
+	hdr, e = parseHeader(r)
+	assert.Nil(e)
+	assert.Equal(&header{checkSum: 1, compressor: Snappy, compressedSize: 2, numRecords: 3}, hdr)
+	assert.Equal(1, r.Len()) // The extension has been skipped.
+
+	// A bit flip in the version is a corruption.
+	v2[4] = headerVersion + 1
+	_, e = parseHeader(bytes.NewReader(v2))
+	assert.Equal(ErrCorruptHeader, e)
+
+	// A header from the future.
+	binary.LittleEndian.PutUint32(v2[len(v2)-4:], crc32.ChecksumIEEE(v2[:len(v2)-4]))
+	_, e = parseHeader(bytes.NewReader(v2))
+	assert.Equal(UnsupportedVersionError(headerVersion+1), e)
+	assert.Contains(e.Error(), "Unsupported chunk header version")
+}
+
 func TestWriteAndRead(t *testing.T) {
 	assert := assert.New(t)
 
@@ -61,8 +124,8 @@ func TestWriteAndRead(t *testing.T) {
 	assert.Equal([]int{2, 3}, idx.accumChunkLens)
 	assert.Equal(
 		[]int64{0,
-			int64(4 + // magic number
-				unsafe.Sizeof(header{}) +
+			int64(headerV2Size +
+				4 + // header checksum
 				5 + // first record
 				4 + // second record
 				2*4)}, // two record legnths
@@ -88,3 +151,170 @@ func TestWriteEmptyFile(t *testing.T) {
 	assert.Nil(e)
 	assert.Equal(0, idx.NumRecords())
 }
+
+func TestReadChunkUnknownCompressor(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	hdr := &header{compressor: 999, compressedSize: 0, numRecords: 1}
+	_, e := hdr.write(&buf)
+	assert.Nil(e)
+
+	_, e = readChunk(&buf)
+	assert.Equal(UnknownCompressorError(999), e)
+}
+
+func TestIndexFooter(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 10, Snappy)
+	for i := 0; i < 100; i++ {
+		_, e := w.Write(bytes.Repeat([]byte{'x'}, i%7))
+		assert.Nil(e)
+	}
+	assert.Nil(w.Close())
+
+	scanned, e := scanIndex(bytes.NewReader(buf.Bytes()), &ScanOptions{})
+	assert.Nil(e)
+	assert.Equal(100, scanned.NumRecords())
+
+	idx, e := readFooter(bytes.NewReader(buf.Bytes()))
+	assert.Nil(e)
+	assert.Equal(scanned, idx)
+
+	idx, e = LoadIndex(bytes.NewReader(buf.Bytes()))
+	assert.Nil(e)
+	assert.Equal(scanned, idx)
+
+	// A legacy file without the footer.
+	legacy := buf.Bytes()[:w.offset]
+	assert.True(len(legacy) < buf.Len())
+	_, e = readFooter(bytes.NewReader(legacy))
+	assert.Equal(errNoFooter, e)
+	idx, e = LoadIndex(bytes.NewReader(legacy))
+	assert.Nil(e)
+	assert.Equal(scanned, idx)
+
+	// A damaged footer falls back to scanning.
+	damaged := append([]byte(nil), buf.Bytes()...)
+	damaged[len(legacy)+indexHeaderSize]++
+	_, e = readFooter(bytes.NewReader(damaged))
+	assert.Equal(errNoFooter, e)
+	idx, e = LoadIndex(bytes.NewReader(damaged))
+	assert.Nil(e)
+	assert.Equal(scanned, idx)
+}
+
+func TestConcatenatedFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	var ends []int
+	for f := 0; f < 2; f++ {
+		w := NewWriter(&buf, 10, Snappy)
+		for i := 0; i < 10; i++ {
+			_, e := w.Write([]byte{byte(10*f + i)})
+			assert.Nil(e)
+		}
+		assert.Nil(w.Close())
+		ends = append(ends, buf.Len())
+	}
+	data := buf.Bytes()
+
+	records := func(data []byte, idx *Index) []byte {
+		var rs []byte
+		s := NewScanner(bytes.NewReader(data), idx, -1, -1)
+		for s.Scan() {
+			rs = append(rs, s.Record()...)
+		}
+		assert.Equal(io.EOF, s.Error())
+		return rs
+	}
+	var want []byte
+	for i := 0; i < 20; i++ {
+		want = append(want, byte(i))
+	}
+
+	// Scanning skips the footer in the middle.
+	idx, e := LoadIndex(bytes.NewReader(data))
+	assert.Nil(e)
+	assert.Equal(20, idx.NumRecords())
+	assert.Equal(want, records(data, idx))
+
+	// Recover writes a single footer.
+	var dst bytes.Buffer
+	rpt, e := Recover(bytes.NewReader(data), &dst)
+	assert.Nil(e)
+	assert.Empty(rpt.Dropped)
+	assert.Equal(20, rpt.Records)
+	idx, e = readFooter(bytes.NewReader(dst.Bytes()))
+	assert.Nil(e)
+	assert.Equal(want, records(dst.Bytes(), idx))
+
+	// A damaged footer in the middle fails instead of ending the index.
+	damaged := append([]byte(nil), data...)
+	damaged[ends[0]-trailerSize-1]++
+	_, e = LoadIndex(bytes.NewReader(damaged))
+	assert.Equal(ErrCorruptHeader, e)
+}
+
+func TestWideChunks(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(n int) { maxNarrowChunkSize = n }(maxNarrowChunkSize)
+	// Chunks holding more than 12 bytes, including length prefixes,
+	// are wide.
+	maxNarrowChunkSize = 12
+
+	for _, compressor := range []int{NoCompression, Snappy, Gzip, Zstd} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, 20, compressor)
+		data := []string{"12345", "67890", "1234", "12", "1234567"}
+		for _, r := range data {
+			_, e := w.Write([]byte(r))
+			assert.Nil(e)
+		}
+		assert.Nil(w.Close())
+
+		scanned, e := scanIndex(bytes.NewReader(buf.Bytes()), &ScanOptions{})
+		assert.Nil(e)
+		idx, e := LoadIndex(bytes.NewReader(buf.Bytes()))
+		assert.Nil(e)
+		assert.Equal(scanned, idx)
+		assert.Equal([]int{4, 1}, idx.chunkRecords)
+
+		r := bytes.NewReader(buf.Bytes())
+		var wide []bool
+		for _, o := range idx.chunkOffsets {
+			r.Seek(o, io.SeekStart)
+			hdr, e := parseHeader(r)
+			assert.Nil(e)
+			wide = append(wide, hdr.wide())
+		}
+		assert.Equal([]bool{true, false}, wide)
+
+		s := NewScanner(bytes.NewReader(buf.Bytes()), idx, -1, -1)
+		var got []string
+		for s.Scan() {
+			got = append(got, string(s.Record()))
+		}
+		assert.Equal(io.EOF, s.Error())
+		assert.Equal(data, got)
+	}
+
+	// Length prefixes of empty records count too.
+	var buf bytes.Buffer
+	ch := &chunk{}
+	for i := 0; i < 4; i++ {
+		ch.add(nil)
+	}
+	_, e := ch.write(&buf, NoCompression, DefaultLevel)
+	assert.Nil(e)
+	hdr, e := parseHeader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(e)
+	assert.True(hdr.wide())
+	ch, e = readChunk(bytes.NewReader(buf.Bytes()))
+	assert.Nil(e)
+	assert.Equal(4, len(ch.records))
+}
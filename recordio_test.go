@@ -3,7 +3,9 @@ package recordio
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"os"
@@ -48,6 +50,68 @@ func TestWriteRead(t *testing.T) {
 	}
 }
 
+func TestWriteReadCompressors(t *testing.T) {
+	const total = 500
+	for _, c := range []struct {
+		compressor, level int
+	}{
+		{NoCompression, DefaultLevel},
+		{Snappy, DefaultLevel},
+		{Gzip, DefaultLevel},
+		{Gzip, 9},
+		{Zstd, DefaultLevel},
+		{Zstd, 1},
+		{Zstd, 19},
+		{LZ4, DefaultLevel},
+		{LZ4, 0},
+		{LZ4, 9},
+	} {
+		name, _ := CompressorName(uint32(c.compressor))
+		t.Run(fmt.Sprintf("%s-%d", name, c.level), func(t *testing.T) {
+			a := assert.New(t)
+
+			var buf bytes.Buffer
+			w, e := NewWriterLevel(&buf, 4096, c.compressor, c.level)
+			a.NoError(e)
+			for i := 0; i < total; i++ {
+				_, e := w.Write(bytes.Repeat([]byte{byte(i)}, i%64))
+				a.NoError(e)
+			}
+			a.NoError(w.Close())
+
+			idx, e := LoadIndex(bytes.NewReader(buf.Bytes()))
+			a.NoError(e)
+			a.Equal(total, idx.NumRecords())
+
+			s := NewScanner(bytes.NewReader(buf.Bytes()), idx, -1, -1)
+			i := 0
+			for s.Scan() {
+				a.Equal(bytes.Repeat([]byte{byte(i)}, i%64), s.Record())
+				i++
+			}
+			a.Equal(io.EOF, s.Error())
+			a.Equal(total, i)
+		})
+	}
+}
+
+func TestInvalidCompressionLevel(t *testing.T) {
+	a := assert.New(t)
+
+	for _, c := range []struct{ compressor, level int }{
+		{Zstd, 23},
+		{LZ4, 10},
+		{Gzip, 10},
+	} {
+		var buf bytes.Buffer
+		w, e := NewWriterLevel(&buf, -1, c.compressor, c.level)
+		a.Error(e)
+		a.Nil(w)
+	}
+	_, e := NewWriterLevel(ioutil.Discard, -1, 12345, DefaultLevel)
+	a.Equal(UnknownCompressorError(12345), e)
+}
+
 func TestWriteAndReadBigRecords(t *testing.T) {
 	a := assert.New(t)
 
@@ -142,3 +206,236 @@ func synthesizeTempFile(records int) (fn string, e error) {
 
 	return fn, nil
 }
+
+// xorWriter and xorReader implement a toy codec for testing
+// RegisterCompressor.
+type xorWriter struct{ w io.Writer }
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	q := make([]byte, len(p))
+	for i := range p {
+		q[i] = p[i] ^ 0x5a
+	}
+	return x.w.Write(q)
+}
+
+func (x xorWriter) Close() error { return nil }
+
+type xorReader struct{ r io.Reader }
+
+func (x xorReader) Read(p []byte) (int, error) {
+	n, e := x.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= 0x5a
+	}
+	return n, e
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	a := assert.New(t)
+
+	const xor = 100
+	newWriter := func(w io.Writer, level int) (io.WriteCloser, error) { return xorWriter{w}, nil }
+	newReader := func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(xorReader{r}), nil }
+	RegisterCompressor(xor, "xor", newWriter, newReader)
+	defer unregisterCompressor(xor)
+	a.PanicsWithValue("recordio: RegisterCompressor called twice for ID 100 (xor and xor2)",
+		func() { RegisterCompressor(xor, "xor2", newWriter, newReader) })
+	a.Panics(func() { RegisterCompressor(xor+1, "nil", nil, newReader) })
+	_, ok := CompressorName(xor + 1)
+	a.False(ok)
+
+	name, ok := CompressorName(xor)
+	a.True(ok)
+	a.Equal("xor", name)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 10, xor)
+	for _, r := range []string{"Hello", "World,", "RecordIO!"} {
+		_, e := w.Write([]byte(r))
+		a.NoError(e)
+	}
+	a.NoError(w.Close())
+	a.NotContains(buf.String(), "Hello")
+
+	idx, e := LoadIndex(bytes.NewReader(buf.Bytes()))
+	a.NoError(e)
+	s := NewScanner(bytes.NewReader(buf.Bytes()), idx, -1, -1)
+	var got []string
+	for s.Scan() {
+		got = append(got, string(s.Record()))
+	}
+	a.Equal([]string{"Hello", "World,", "RecordIO!"}, got)
+}
+
+func TestUnknownCompressor(t *testing.T) {
+	a := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 10, 12345)
+	_, e := w.Write([]byte("Hello"))
+	a.NoError(e)
+	a.Equal(UnknownCompressorError(12345), w.Close())
+}
+
+func TestRecover(t *testing.T) {
+	a := assert.New(t)
+
+	for _, compressor := range []int{NoCompression, Snappy, Gzip} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, 90, compressor)
+		for i := 0; i < 30; i++ { // 3 chunks of 10 records.
+			_, e := w.Write([]byte(fmt.Sprintf("record-%02d", i)))
+			a.NoError(e)
+		}
+		a.NoError(w.Close())
+		data := buf.Bytes()
+		idx, e := LoadIndex(bytes.NewReader(data))
+		a.NoError(e)
+		a.Equal([]int{10, 20, 30}, idx.accumChunkLens)
+
+		recoverBytes := func(src []byte) ([]string, RecoverReport) {
+			var dst bytes.Buffer
+			rpt, e := Recover(bytes.NewReader(src), &dst)
+			a.NoError(e)
+			idx, e := LoadIndex(bytes.NewReader(dst.Bytes()))
+			a.NoError(e)
+			a.Equal(rpt.Records, idx.NumRecords())
+			var got []string
+			s := NewScanner(bytes.NewReader(dst.Bytes()), idx, -1, -1)
+			for s.Scan() {
+				got = append(got, string(s.Record()))
+			}
+			a.Equal(io.EOF, s.Error())
+			return got, rpt
+		}
+
+		// An intact file.
+		got, rpt := recoverBytes(data)
+		a.Equal(30, len(got))
+		a.Equal(3, rpt.Chunks)
+		a.Empty(rpt.Dropped)
+
+		// The writer died in the middle of the last chunk.
+		truncated := data[:idx.chunkOffsets[2]+(idx.chunkOffsets[2]-idx.chunkOffsets[1])*2/3]
+		got, rpt = recoverBytes(truncated)
+		a.Equal(2, rpt.Chunks)
+		a.Equal(1, len(rpt.Dropped))
+		a.Equal(io.ErrUnexpectedEOF, rpt.Dropped[0].Err)
+		a.Equal(20+rpt.Salvaged, len(got))
+		if compressor != Gzip { // gzip buffers too much to salvage.
+			a.True(rpt.Salvaged > 0)
+		}
+		for i, r := range got {
+			a.Equal(fmt.Sprintf("record-%02d", i), r)
+		}
+
+		// The second chunk is corrupted.
+		corrupted := append([]byte(nil), data...)
+		corrupted[idx.chunkOffsets[1]+headerV2Size+4+10] ^= 0xff
+		got, rpt = recoverBytes(corrupted)
+		a.Equal(2, rpt.Chunks)
+		a.Equal(20, len(got))
+		a.Equal(1, len(rpt.Dropped))
+		a.Equal(idx.chunkOffsets[1], rpt.Dropped[0].Offset)
+		a.Equal(idx.chunkOffsets[2]-idx.chunkOffsets[1], rpt.Dropped[0].Size)
+		a.Equal(10, rpt.Dropped[0].Records)
+	}
+}
+
+func TestRecoverMagicInPayload(t *testing.T) {
+	a := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 30, NoCompression)
+	r := make([]byte, 10)
+	binary.LittleEndian.PutUint32(r[3:7], magicNumberV2)
+	for i := 0; i < 6; i++ { // 2 chunks of 3 records.
+		_, e := w.Write(r)
+		a.NoError(e)
+	}
+	a.NoError(w.Close())
+	data := buf.Bytes()
+	idx, e := LoadIndex(bytes.NewReader(data))
+	a.NoError(e)
+	a.Equal(2, idx.NumChunks())
+
+	// The payload of the first chunk fails the checksum.
+	corrupted := append([]byte(nil), data...)
+	corrupted[idx.chunkOffsets[1]-1] ^= 0xff
+	rpt, e := Recover(bytes.NewReader(corrupted), ioutil.Discard)
+	a.NoError(e)
+	a.Equal(1, rpt.Chunks)
+	a.Equal(3, rpt.Records)
+	a.Equal(1, len(rpt.Dropped))
+	a.Equal(idx.chunkOffsets[1], rpt.Dropped[0].Size)
+
+	// The truncated last chunk has an unknown compressor.
+	buf.Reset()
+	w = NewWriter(&buf, 20, NoCompression)
+	for i := 0; i < 6; i++ { // 2 chunks of 3 records.
+		_, e := w.Write([]byte("record"))
+		a.NoError(e)
+	}
+	a.NoError(w.Close())
+	idx, e = LoadIndex(bytes.NewReader(buf.Bytes()))
+	a.NoError(e)
+	truncated := buf.Bytes()[:idx.chunkOffsets[1]+headerV2Size+4+15]
+	hdr := truncated[idx.chunkOffsets[1]:]
+	binary.LittleEndian.PutUint32(hdr[12:16], 12345)
+	binary.LittleEndian.PutUint32(hdr[24:28], crc32.ChecksumIEEE(hdr[:24]))
+	var dst bytes.Buffer
+	rpt, e = Recover(bytes.NewReader(truncated), &dst)
+	a.NoError(e)
+	a.Equal(1, rpt.Chunks)
+	a.Equal(0, rpt.Salvaged)
+	a.Equal(1, len(rpt.Dropped))
+	a.Equal(idx.chunkOffsets[1], rpt.Dropped[0].Offset)
+	a.Error(rpt.Dropped[0].Err)
+	idx, e = LoadIndex(bytes.NewReader(dst.Bytes()))
+	a.NoError(e)
+	a.Equal(3, idx.NumRecords())
+}
+
+func TestRecoverUnknownCompressor(t *testing.T) {
+	a := assert.New(t)
+
+	const xor = 100
+	newWriter := func(w io.Writer, level int) (io.WriteCloser, error) { return xorWriter{w}, nil }
+	newReader := func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(xorReader{r}), nil }
+	RegisterCompressor(xor, "xor", newWriter, newReader)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 20, xor)
+	for i := 0; i < 6; i++ { // 2 chunks of 3 records.
+		_, e := w.Write([]byte(fmt.Sprintf("recrd%d", i)))
+		a.NoError(e)
+	}
+	a.NoError(w.Close())
+	unregisterCompressor(xor)
+	idx, e := LoadIndex(bytes.NewReader(buf.Bytes()))
+	a.NoError(e)
+	a.Equal(2, idx.NumChunks())
+
+	// Intact chunks are copied as they are.
+	var dst bytes.Buffer
+	rpt, e := Recover(bytes.NewReader(buf.Bytes()), &dst)
+	a.NoError(e)
+	a.Equal(2, rpt.Chunks)
+	a.Equal(6, rpt.Records)
+	a.Empty(rpt.Dropped)
+	a.Equal(buf.Bytes(), dst.Bytes())
+
+	// The truncated last chunk can't be salvaged.
+	dst.Reset()
+	truncated := buf.Bytes()[:idx.chunkOffsets[1]+headerV2Size+4+15]
+	rpt, e = Recover(bytes.NewReader(truncated), &dst)
+	a.NoError(e)
+	a.Equal(1, rpt.Chunks)
+	a.Equal(3, rpt.Records)
+	a.Equal(1, len(rpt.Dropped))
+	a.Equal(idx.chunkOffsets[1], rpt.Dropped[0].Offset)
+	a.Contains(rpt.Dropped[0].Err.Error(), UnknownCompressorError(xor).Error())
+	idx, e = LoadIndex(bytes.NewReader(dst.Bytes()))
+	a.NoError(e)
+	a.Equal(3, idx.NumRecords())
+}
@@ -0,0 +1,274 @@
+package recordio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// RecoverReport summarizes the result of Recover.
+type RecoverReport struct {
+	Chunks   int          // intact chunks copied.
+	Records  int          // records written, including salvaged ones.
+	Salvaged int          // records salvaged from a truncated last chunk.
+	Dropped  []Corruption // ranges of the source that were not copied.
+}
+
+// Recover copies every intact chunk in src to dst and appends an
+// index footer, so dst is a valid RecordIO file even if src is not,
+// e.g., because the writer process died before Writer.Close.  It
+// skips corrupted chunks by searching for the next magic number.
+// It copies intact chunks without decompressing them, so it keeps
+// chunks written with compressors unknown to it.  If the last chunk
+// is truncated, Recover salvages its complete records into a new
+// chunk; their checksum cannot be verified.
+func Recover(src io.ReadSeeker, dst io.Writer) (RecoverReport, error) {
+	var rpt RecoverReport
+
+	size, e := src.Seek(0, io.SeekEnd)
+	if e != nil {
+		return rpt, e
+	}
+
+	var offsets []int64
+	var records []int
+	written := int64(0)
+
+	for offset := int64(0); offset < size; {
+		raw, hdr, e := readRawChunk(src, offset, size)
+		if e == io.EOF {
+			if end, ok := footerEnd(src, offset); ok {
+				offset = end // Skip footers of concatenated files.
+				continue
+			}
+			if isFooter(src, offset, size) {
+				break
+			}
+			e = ErrCorruptHeader // A footer magic number in a wrong place.
+		}
+
+		if e == nil {
+			e = checkChunk(hdr, raw)
+		}
+		if e != nil {
+			// Skip the payload of a chunk whose header is intact, which
+			// could contain magic numbers.
+			from := offset + 1
+			if hdr != nil && e != io.ErrUnexpectedEOF && checkedHeader(raw) {
+				from = offset + int64(len(raw))
+			}
+			next, ee := resync(src, from, size)
+			if ee != nil {
+				return rpt, ee
+			}
+			lost := -1
+			if hdr != nil {
+				lost = int(hdr.numRecords)
+			}
+			rpt.Dropped = append(rpt.Dropped, Corruption{
+				Offset: offset, Size: next - offset, Records: lost, Err: e})
+
+			if e == io.ErrUnexpectedEOF && hdr != nil && next == size {
+				// The last chunk is truncated.
+				ch, e := salvage(hdr, raw)
+				if e != nil {
+					// Drop the whole chunk, e.g., if its compressor is unknown.
+					rpt.Dropped[len(rpt.Dropped)-1].Err = e
+					ch = &chunk{}
+				}
+				n := len(ch.records)
+				m, e := ch.write(dst, int(hdr.compressor), DefaultLevel)
+				if e != nil {
+					return rpt, e
+				}
+				if n > 0 {
+					offsets = append(offsets, written)
+					records = append(records, n)
+					written += m
+					rpt.Salvaged = n
+					rpt.Records += n
+				}
+			}
+			offset = next
+			continue
+		}
+
+		if _, e := dst.Write(raw); e != nil {
+			return rpt, fmt.Errorf("Failed to write chunk: %v", e)
+		}
+		offsets = append(offsets, written)
+		records = append(records, int(hdr.numRecords))
+		written += int64(len(raw))
+		offset += int64(len(raw))
+		rpt.Chunks++
+		rpt.Records += int(hdr.numRecords)
+	}
+
+	if len(offsets) > 0 {
+		if e := writeFooter(dst, newIndex(offsets, records), written); e != nil {
+			return rpt, e
+		}
+	}
+	return rpt, nil
+}
+
+// readRawChunk reads the header and the compressed data of the chunk
+// at offset.  If the chunk is truncated, it returns whatever follows
+// a valid header with io.ErrUnexpectedEOF.
+func readRawChunk(r io.ReadSeeker, offset, size int64) ([]byte, *header, error) {
+	if _, e := r.Seek(offset, io.SeekStart); e != nil {
+		return nil, nil, e
+	}
+	hdr, e := parseHeader(r)
+	if e != nil {
+		return nil, nil, e
+	}
+	dataOffset, e := r.Seek(0, io.SeekCurrent)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	end := dataOffset + int64(hdr.compressedSize)
+	if end > size || end < dataOffset {
+		data := make([]byte, size-dataOffset)
+		if _, e := io.ReadFull(r, data); e != nil {
+			return nil, nil, e
+		}
+		return data, hdr, io.ErrUnexpectedEOF
+	}
+
+	raw := make([]byte, end-offset)
+	if _, e := r.Seek(offset, io.SeekStart); e != nil {
+		return nil, nil, e
+	}
+	if _, e := io.ReadFull(r, raw); e != nil {
+		return nil, nil, e
+	}
+	return raw, hdr, nil
+}
+
+// checkChunk checks the chunk raw, read by readRawChunk, against the
+// payload checksum.  The checksum doesn't cover a v1 header, so it
+// also decodes the chunk if it can.
+func checkChunk(hdr *header, raw []byte) error {
+	payload := raw[len(raw)-int(hdr.compressedSize):]
+	if sum := crc32.ChecksumIEEE(payload); hdr.checkSum != sum {
+		return fmt.Errorf("Checksum checking failed. %d vs %d", hdr.checkSum, sum)
+	}
+	if checkedHeader(raw) {
+		return nil
+	}
+	if _, ok := lookupCompressor(hdr.compressor); !ok {
+		return nil
+	}
+	_, e := decodePayload(hdr, payload, nil)
+	return e
+}
+
+// salvage decodes complete records from the truncated compressed
+// data of a chunk into a new chunk.
+func salvage(hdr *header, data []byte) (*chunk, error) {
+	decomp, e := newDecompressor(bytes.NewReader(data), int(hdr.compressor))
+	if e != nil {
+		return nil, fmt.Errorf("Failed to salvage the last chunk: %v", e)
+	}
+	defer decomp.Close()
+
+	ch := &chunk{}
+	for i := 0; i < int(hdr.numRecords); i++ {
+		l, e := readRecordLen(decomp, hdr.wide())
+		if e != nil {
+			break
+		}
+		// Don't trust l to allocate the record at once.
+		var r bytes.Buffer
+		if _, e := io.CopyN(&r, decomp, int64(l)); e != nil {
+			break
+		}
+		ch.add(r.Bytes())
+	}
+	return ch, nil
+}
+
+// checkedHeader returns true if the chunk raw, which has been parsed
+// successfully, begins with a header protected by a checksum.
+func checkedHeader(raw []byte) bool {
+	return len(raw) > 5 &&
+		binary.LittleEndian.Uint32(raw[0:4]) == magicNumberV2 &&
+		raw[5]&flagHeaderChecksum != 0
+}
+
+// peek returns n bytes at offset of r, or nil if it fails to read
+// them.
+func peek(r io.ReadSeeker, offset int64, n int) []byte {
+	b := make([]byte, n)
+	if _, e := r.Seek(offset, io.SeekStart); e != nil {
+		return nil
+	}
+	if _, e := io.ReadFull(r, b); e != nil {
+		return nil
+	}
+	return b
+}
+
+// v2HeaderAt returns true if offset of r begins with the magic number
+// of v2 headers.
+func v2HeaderAt(r io.ReadSeeker, offset int64) bool {
+	b := peek(r, offset, 4)
+	return b != nil && binary.LittleEndian.Uint32(b) == magicNumberV2
+}
+
+// plausibleHeader returns true if the magic number at offset, found by
+// resync in a file of the given size, begins the index footer or a
+// chunk header.  If checked, it must be a checksummed header.
+// Otherwise, a header without checksum must begin a chunk that fits in
+// the file and decodes.
+func plausibleHeader(r io.ReadSeeker, offset, size int64, checked bool) bool {
+	raw, hdr, e := readRawChunk(r, offset, size)
+	switch {
+	case e == io.EOF:
+		_, ok := footerEnd(r, offset)
+		return ok || isFooter(r, offset, size)
+	case hdr == nil:
+		return false
+	case checkedHeader(peek(r, offset, 6)):
+		return true // Even if the chunk is truncated.
+	case checked || e != nil:
+		return false
+	}
+	_, e = decodePayload(hdr, raw[len(raw)-int(hdr.compressedSize):], nil)
+	return e == nil
+}
+
+// resync returns the offset of the next magic number of a chunk
+// header or the index footer at or after from, or size if there is
+// none.
+func resync(r io.ReadSeeker, from, size int64) (int64, error) {
+	const bufSize = 64 * 1024
+	buf := make([]byte, bufSize)
+	for pos := from; pos+4 <= size; pos += bufSize - 3 {
+		if _, e := r.Seek(pos, io.SeekStart); e != nil {
+			return 0, e
+		}
+		n, e := io.ReadFull(r, buf)
+		if e != nil && e != io.ErrUnexpectedEOF {
+			return 0, e
+		}
+		for i := 0; i+4 <= n; i++ {
+			switch binary.LittleEndian.Uint32(buf[i : i+4]) {
+			case magicNumber, magicNumberV2, footerMagicNumber:
+				return pos + int64(i), nil
+			}
+		}
+	}
+	return size, nil
+}
+
+// isFooter returns true if the index footer of a file of the given
+// size starts at offset.
+func isFooter(r io.ReadSeeker, offset, size int64) bool {
+	o, ok := footerOffset(r, size)
+	return ok && o == offset
+}
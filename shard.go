@@ -0,0 +1,93 @@
+package recordio
+
+import "sort"
+
+// Range is the range of records [Start, Start+Len) in a FileList.
+type Range struct {
+	Start, Len int
+}
+
+// Shards splits records in fl into n consecutive ranges, whose sizes
+// differ by at most one.  Each worker could scan its own range using
+// NewFileListScanner(fl, r.Start, r.Len).
+func (fl *FileList) Shards(n int) []Range {
+	if n <= 0 {
+		return nil
+	}
+	rs := make([]Range, n)
+	for i := range rs {
+		rs[i] = fl.Shard(i, n)
+	}
+	return rs
+}
+
+// Shard returns the i-th of Shards(n).  It returns an empty Range if
+// n <= 0 or i is not in [0, n).
+func (fl *FileList) Shard(i, n int) Range {
+	if n <= 0 || i < 0 || i >= n {
+		return Range{}
+	}
+	total := fl.TotalRecords()
+	q, r := total/n, total%n
+	if i < r {
+		return Range{Start: i * (q + 1), Len: q + 1}
+	}
+	return Range{Start: i*q + r, Len: q}
+}
+
+// ChunkAlignedShards is like Shards, but the ranges begin and end at
+// chunk boundaries, so no two workers decode the same chunk.  The
+// ranges are less balanced, and some are empty if fl has fewer chunks
+// than n.  It loads indices of all files, and treats a file whose
+// index fails to load as a chunk.
+func (fl *FileList) ChunkAlignedShards(n int) []Range {
+	if n <= 0 {
+		return nil
+	}
+
+	// Chunk boundaries in the record space of fl, excluding 0.
+	var bounds []int
+	prev := 0
+	for i := range fl.files {
+		if idx, e := fl.index(i); e == nil {
+			for _, a := range idx.accumChunkLens {
+				bounds = append(bounds, prev+a)
+			}
+		} else {
+			bounds = append(bounds, fl.accumFileLens[i])
+		}
+		prev = fl.accumFileLens[i]
+	}
+
+	rs := make([]Range, n)
+	start := 0
+	for i := range rs {
+		end := fl.TotalRecords()
+		if i < n-1 {
+			// The nearest boundary to the end of the balanced shard.
+			target := fl.Shard(i, n)
+			end = nearest(bounds, target.Start+target.Len)
+			if end < start {
+				end = start
+			}
+		}
+		rs[i] = Range{Start: start, Len: end - start}
+		start = end
+	}
+	return rs
+}
+
+// nearest returns the value in the sorted bounds closest to x, or 0 if
+// bounds is empty.
+func nearest(bounds []int, x int) int {
+	i := sort.SearchInts(bounds, x)
+	switch {
+	case len(bounds) == 0:
+		return 0
+	case i == len(bounds):
+		return bounds[i-1]
+	case i > 0 && x-bounds[i-1] <= bounds[i]-x:
+		return bounds[i-1]
+	}
+	return bounds[i]
+}
@@ -0,0 +1,132 @@
+package recordio
+
+import "math/rand"
+
+// defaultShuffleWindow is the default number of chunks whose records
+// are shuffled together.
+const defaultShuffleWindow = 16
+
+// Shuffle makes FileListScanner emit records in a pseudo-random order
+// determined by seed and the epoch.  It permutes chunks in the range
+// across all files, and then records in each window of window
+// consecutive chunks in the permuted order, so it keeps about window
+// chunks in the memory.  Larger windows mix records better.  The
+// order doesn't depend on other options like Workers.
+func Shuffle(seed int64, window int) ScanOption {
+	return func(o *ScanOptions) {
+		o.Shuffle = true
+		o.Seed = seed
+		o.ShuffleWindow = window
+	}
+}
+
+// ResumeAt makes FileListScanner start from the offset-th record it
+// would emit in the given epoch, e.g., the number of records consumed
+// before a checkpoint.  If shuffling, it doesn't read chunks in
+// windows before the offset.  Chunks skipped by SkipCorrupt before
+// the checkpoint shift the offset.  Scanning fails if epoch or offset
+// is negative.
+func ResumeAt(epoch, offset int) ScanOption {
+	return func(o *ScanOptions) {
+		o.Epoch = epoch
+		o.Offset = offset
+	}
+}
+
+// shuffleTasks permutes tasks, assigns them to windows of size
+// chunks, and drops windows before offset.  It returns the rest of
+// tasks and the number of records to skip in the first window.
+func shuffleTasks(tasks []*chunkTask, seed int64, epoch, offset, size int) ([]*chunkTask, int) {
+	rng := rand.New(rand.NewSource(mixSeed(seed, int64(epoch))))
+	rng.Shuffle(len(tasks), func(i, j int) { tasks[i], tasks[j] = tasks[j], tasks[i] })
+	for i, t := range tasks {
+		t.window = i / size
+	}
+
+	skip := offset
+	for len(tasks) > 0 {
+		n, end := 0, 0
+		for end < len(tasks) && tasks[end].window == tasks[0].window {
+			n += tasks[end].todo
+			end++
+		}
+		if n > skip {
+			break
+		}
+		skip -= n
+		tasks = tasks[end:]
+	}
+	return tasks, skip
+}
+
+// window collects records of chunks in a shuffle window.
+type window struct {
+	epoch   int
+	index   int // -1 before the first window.
+	records []Record
+	skip    int // records to drop after shuffling, for resuming.
+	offset  int // Record.Offset of the next record to emit.
+}
+
+// shuffle adds records of t into w, after emitting w if t belongs to
+// the next window.
+func (scnr *FileListScanner) shuffle(w *window, t *chunkTask) error {
+	if t.window != w.index {
+		if e := scnr.flush(w); e != nil {
+			return e
+		}
+		w.index = t.window
+	}
+	if t.err != nil {
+		return scnr.corrupt(t)
+	}
+
+	for i := 0; i < t.todo && t.record+i < len(t.chunk.records); i++ {
+		w.records = append(w.records, Record{
+			Epoch: w.epoch,
+			Index: t.first + i,
+			Data:  t.chunk.records[t.record+i],
+		})
+	}
+	return nil
+}
+
+// flush emits records in w in a random order determined by the seed,
+// the epoch, and the window.
+func (scnr *FileListScanner) flush(w *window) error {
+	if w.index < 0 {
+		return nil
+	}
+
+	o := &scnr.opts
+	rng := rand.New(rand.NewSource(mixSeed(o.Seed, int64(w.epoch), int64(w.index))))
+	rs := w.records
+	rng.Shuffle(len(rs), func(i, j int) { rs[i], rs[j] = rs[j], rs[i] })
+	if w.skip > len(rs) {
+		w.skip = len(rs)
+	}
+	for _, r := range rs[w.skip:] {
+		r.Offset = w.offset
+		if e := scnr.send(r); e != nil {
+			return e
+		}
+		w.offset++
+	}
+	w.records = rs[:0]
+	w.skip = 0
+	return nil
+}
+
+// mixSeed derives a seed from vs using SplitMix64, so that nearby
+// values, like consecutive epochs, give unrelated random sequences.
+func mixSeed(vs ...int64) int64 {
+	h := uint64(0x9e3779b97f4a7c15)
+	for _, v := range vs {
+		h ^= uint64(v)
+		h += 0x9e3779b97f4a7c15
+		h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+		h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+		h ^= h >> 31
+	}
+	return int64(h)
+}
@@ -3,6 +3,7 @@ package recordio
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 )
 
 const (
@@ -15,12 +16,38 @@ type Writer struct {
 	chunk        *chunk
 	maxChunkSize int // total records size, excluding metadata, before compression.
 	compressor   int
+	level        int
+
+	// For the index footer.
+	offset       int64
+	chunkOffsets []int64
+	chunkRecords []int
 }
 
 // NewWriter creates a RecordIO file writer.  Each chunk is compressed
 // using the deflate algorithm given compression level.  Note that
 // level 0 means no compression and -1 means default compression.
+// w may append to an existing RecordIO file; LoadIndex scans such
+// concatenated files.
 func NewWriter(w io.Writer, maxChunkSize, compressor int) *Writer {
+	return newWriter(w, maxChunkSize, compressor, DefaultLevel)
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression
+// level instead of using the compressor's default.  The range of
+// valid levels depends on the compressor.  It returns an error if the
+// compressor is unknown or the level is invalid.
+func NewWriterLevel(w io.Writer, maxChunkSize, compressor, level int) (*Writer, error) {
+	wr := newWriter(w, maxChunkSize, compressor, level)
+	compr, e := newCompressor(ioutil.Discard, wr.compressor, level)
+	if e != nil {
+		return nil, e
+	}
+	compr.Close()
+	return wr, nil
+}
+
+func newWriter(w io.Writer, maxChunkSize, compressor, level int) *Writer {
 	if maxChunkSize <= 0 {
 		maxChunkSize = defaultMaxChunkSize
 	}
@@ -33,7 +60,8 @@ func NewWriter(w io.Writer, maxChunkSize, compressor int) *Writer {
 		Writer:       w,
 		chunk:        &chunk{},
 		maxChunkSize: maxChunkSize,
-		compressor:   compressor}
+		compressor:   compressor,
+		level:        level}
 }
 
 // Writes a record.  It returns an error if Close has been called.
@@ -47,7 +75,7 @@ func (w *Writer) Write(record []byte) (int, error) {
 	}
 
 	if w.chunk.numBytes+len(record) > w.maxChunkSize {
-		if e := w.chunk.write(w.Writer, w.compressor); e != nil {
+		if e := w.flush(); e != nil {
 			return 0, e
 		}
 	}
@@ -56,12 +84,33 @@ func (w *Writer) Write(record []byte) (int, error) {
 	return len(record), nil
 }
 
-// Close flushes the current chunk and makes the writer invalid.
+// flush writes the current chunk and remembers its offset and size
+// for the index footer.
+func (w *Writer) flush() error {
+	n := len(w.chunk.records)
+	size, e := w.chunk.write(w.Writer, w.compressor, w.level)
+	if e != nil || n == 0 {
+		return e
+	}
+	w.chunkOffsets = append(w.chunkOffsets, w.offset)
+	w.chunkRecords = append(w.chunkRecords, n)
+	w.offset += size
+	return nil
+}
+
+// Close flushes the current chunk, appends the index footer, and
+// makes the writer invalid.
 func (w *Writer) Close() error {
 	defer func() { w.Writer = nil }()
-	if e := w.chunk.write(w.Writer, w.compressor); e != nil {
+	if e := w.flush(); e != nil {
 		return e
 	}
+	if len(w.chunkOffsets) > 0 {
+		idx := newIndex(w.chunkOffsets, w.chunkRecords)
+		if e := writeFooter(w.Writer, idx, w.offset); e != nil {
+			return e
+		}
+	}
 	if wc, ok := w.Writer.(io.WriteCloser); ok {
 		return wc.Close()
 	}